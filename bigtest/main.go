@@ -24,8 +24,6 @@ var (
 	scReturn genesm.Event
 )
 
-var sceneMap = map[genesm.StateID]string{}
-
 const (
 	WWidth   = 800
 	WHeight  = 600
@@ -83,14 +81,10 @@ func initMgr(ctx context.Context, wd *Window) <-chan struct{} {
 	sm := genesm.NewStateMachine(wd)
 
 	// bind scene as a state
-	scbind0 := genesm.RegState(sm, scroot)
-	scbind1 := genesm.RegState(sm, sc1)
-	scbind2 := genesm.RegState(sm, sc2)
-	scbind3 := genesm.RegState(sm, sc2x1)
-	sceneMap[scbind0.ID()] = "root"
-	sceneMap[scbind1.ID()] = "scene one"
-	sceneMap[scbind2.ID()] = "scene two"
-	sceneMap[scbind3.ID()] = "scene two/sub"
+	scbind0 := genesm.RegState(sm, scroot, "root")
+	scbind1 := genesm.RegState(sm, sc1, "scene one")
+	scbind2 := genesm.RegState(sm, sc2, "scene two")
+	scbind3 := genesm.RegState(sm, sc2x1, "scene two/sub")
 
 	// bind event
 	//      + <<<<<<<<<<<<<<<<<<< +
@@ -108,24 +102,24 @@ func initMgr(ctx context.Context, wd *Window) <-chan struct{} {
 	// create event handler
 	obHndA := genesm.ObsEventFuncs(
 		func(wd *Window, id genesm.StateID, val sceneA) {
-			fmt.Println("Type A Enter:", sceneMap[id])
+			fmt.Println("Type A Enter:", sm.Describe(id))
 		},
 		func(wd *Window, id genesm.StateID, val sceneA) {
-			fmt.Println("Type A Exit:", sceneMap[id])
+			fmt.Println("Type A Exit:", sm.Describe(id))
 		},
 		func(wd *Window, id genesm.StateID, val sceneA) {
-			fmt.Println("Type A SM Pick:", sceneMap[id])
+			fmt.Println("Type A SM Pick:", sm.Describe(id))
 		}, nil,
 	)
 	obHndB := genesm.ObsEventFuncs(
 		func(wd *Window, id genesm.StateID, val sceneB) {
-			fmt.Println("Type B Enter:", sceneMap[id])
+			fmt.Println("Type B Enter:", sm.Describe(id))
 		},
 		func(wd *Window, id genesm.StateID, val sceneB) {
-			fmt.Println("Type B Exit:", sceneMap[id])
+			fmt.Println("Type B Exit:", sm.Describe(id))
 		},
 		func(wd *Window, id genesm.StateID, val sceneB) {
-			fmt.Println("Type B SM Pick:", sceneMap[id])
+			fmt.Println("Type B SM Pick:", sm.Describe(id))
 		}, nil,
 	)
 
@@ -0,0 +1,301 @@
+package genesm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// ErrSpec is the base error of invalid specification
+var ErrSpec = errors.New("invalid spec")
+
+// Spec is a declarative definition of a state machine. it could be loaded from
+// JSON by LoadSpec. fields are tagged for YAML as well, so it could be decoded
+// by any YAML package which respect "yaml" tags.
+//
+// Regions define orthogonal regions of the state machine (see NewRegion).
+// History is history mode of a sub state machine, one of "none" (default),
+// "shallow" and "deep".
+type Spec struct {
+	States  []StateSpec `json:"states" yaml:"states"`
+	Events  []EventSpec `json:"events,omitempty" yaml:"events,omitempty"`
+	Regions []Spec      `json:"regions,omitempty" yaml:"regions,omitempty"`
+	History string      `json:"history,omitempty" yaml:"history,omitempty"`
+}
+
+// StateSpec define a state. Name is required and unique in its state machine.
+// value of the state is bound by name (see Builder.BindState). Sub define a
+// sub state machine of the state.
+//
+// the first state is initial state unless another one is marked Initial.
+//
+// Type is Go type of the value which is used by genesm-gen. Builder ignore
+// it.
+type StateSpec struct {
+	Name    string `json:"name" yaml:"name"`
+	Initial bool   `json:"initial,omitempty" yaml:"initial,omitempty"`
+	Final   bool   `json:"final,omitempty" yaml:"final,omitempty"`
+	Sub     *Spec  `json:"sub,omitempty" yaml:"sub,omitempty"`
+	Type    string `json:"type,omitempty" yaml:"type,omitempty"`
+}
+
+// EventSpec define an event between states of the same state machine.
+//
+// Kind is one of "external" (default), "self" and "internal". To could be
+// omitted for self and internal events. After make a timed event which fire
+// after the duration (in time.ParseDuration format). Hook and Action refer to
+// functions which bound by name (see Builder.BindHook and Builder.BindAction).
+type EventSpec struct {
+	Name   string `json:"name" yaml:"name"`
+	From   string `json:"from" yaml:"from"`
+	To     string `json:"to,omitempty" yaml:"to,omitempty"`
+	Kind   string `json:"kind,omitempty" yaml:"kind,omitempty"`
+	After  string `json:"after,omitempty" yaml:"after,omitempty"`
+	Hook   string `json:"hook,omitempty" yaml:"hook,omitempty"`
+	Action string `json:"action,omitempty" yaml:"action,omitempty"`
+}
+
+// LoadSpec read a JSON specification. unknown fields are rejected
+func LoadSpec(r io.Reader) (*Spec, error) {
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	spec := &Spec{}
+	if err := dec.Decode(spec); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrSpec, err)
+	}
+	return spec, nil
+}
+
+// Builder build state machines from Spec. values of states, hooks and actions
+// are bound by name from Go code.
+//
+// since types are decided by specification, states are registed as
+// StateBinder[O, any] and events are registed as EventX[O, any, any]. use
+// LookupState and LookupEvent to retrieve them by name, such as:
+//
+//	bnd := LookupState[any](sm, "idle")
+//	ev := LookupEvent[EventX[MyOwner, any, any]](sm, "start")
+type Builder[O any] struct {
+	states  map[string]stateValue
+	hooks   map[string]func(context.Context, O, any, any) error
+	actions map[string]func(O, any, any, StateID)
+}
+
+// NewBuilder create a Builder
+func NewBuilder[O any]() *Builder[O] {
+	return &Builder[O]{
+		states:  map[string]stateValue{},
+		hooks:   map[string]func(context.Context, O, any, any) error{},
+		actions: map[string]func(O, any, any, StateID){},
+	}
+}
+
+// stateValue is a bound value of states. if fn is not nil, it create value for
+// each state instead
+type stateValue struct {
+	value any
+	fn    func() any
+}
+
+// get get value for a new state
+func (sv stateValue) get() any {
+	if sv.fn != nil {
+		return sv.fn()
+	}
+	return sv.value
+}
+
+// BindState bind value of states which have the name. a name could be used in
+// several state machines (sub state machines or regions), they are bound to
+// the same value. values of reference kinds (maps, slices, pointers, etc.) are
+// shared by these states, use BindStateFunc to give each state its own one.
+func (bd *Builder[O]) BindState(name string, value any) *Builder[O] {
+	bd.states[name] = stateValue{value: value}
+	return bd
+}
+
+// BindStateFunc bind a function which create value of states by name. it is
+// called once for each state which have the name, on every Build and
+// Template.New
+func (bd *Builder[O]) BindStateFunc(name string, fn func() any) *Builder[O] {
+	bd.states[name] = stateValue{fn: fn}
+	return bd
+}
+
+// BindHook bind a hook function by name. see EventX.SetHookCtx
+func (bd *Builder[O]) BindHook(
+	name string, hook func(context.Context, O, any, any) error,
+) *Builder[O] {
+	bd.hooks[name] = hook
+	return bd
+}
+
+// BindAction bind an action function by name. see EventX.SetAction
+func (bd *Builder[O]) BindAction(
+	name string, action func(O, any, any, StateID),
+) *Builder[O] {
+	bd.actions[name] = action
+	return bd
+}
+
+// Build create a state machine from spec. the whole spec is checked before
+// anything is registed. it return an error which wrap ErrSpec if spec is
+// invalid, or refer to unbound state, hook or action.
+func (bd *Builder[O]) Build(owner O, spec *Spec) (*StateMachine[O], error) {
+	if err := bd.check(spec, ""); err != nil {
+		return nil, err
+	}
+	sm := NewStateMachine(owner)
+	bd.build(sm, spec)
+	return sm, nil
+}
+
+// Check check spec without building anything. it return the same error as
+// Build for an invalid spec
+func (bd *Builder[O]) Check(spec *Spec) error {
+	return bd.check(spec, "")
+}
+
+// check check specification of a state machine recursively. where is path
+// of the state machine which prefix error message
+func (bd *Builder[O]) check(spec *Spec, where string) error {
+	fail := func(format string, args ...any) error {
+		return fmt.Errorf("%w: %s%s", ErrSpec, where, fmt.Sprintf(format, args...))
+	}
+	if len(spec.States) == 0 && len(spec.Regions) == 0 {
+		return fail("no state")
+	}
+	if _, ok := parseHistory(spec.History); !ok {
+		return fail("unknown history mode %q", spec.History)
+	}
+	names := map[string]bool{}
+	initial := false
+	for _, st := range spec.States {
+		if st.Name == "" {
+			return fail("state without name")
+		} else if names[st.Name] {
+			return fail("duplicate state %q", st.Name)
+		} else if _, ok := bd.states[st.Name]; !ok {
+			return fail("state %q is not bound", st.Name)
+		} else if st.Initial && initial {
+			return fail("several initial states")
+		}
+		names[st.Name] = true
+		initial = initial || st.Initial
+		if st.Sub != nil {
+			if err := bd.check(st.Sub, where+st.Name+"/"); err != nil {
+				return err
+			}
+		}
+	}
+	events := map[string]bool{}
+	for _, ev := range spec.Events {
+		if ev.Name == "" {
+			return fail("event without name")
+		} else if events[ev.Name] {
+			return fail("duplicate event %q", ev.Name)
+		}
+		events[ev.Name] = true
+		to := ev.To
+		switch ev.Kind {
+		case "", "external":
+		case "self", "internal":
+			if to == "" {
+				to = ev.From
+			} else if to != ev.From {
+				return fail("event %q: %s event must target its source",
+					ev.Name, ev.Kind)
+			}
+		default:
+			return fail("event %q: unknown kind %q", ev.Name, ev.Kind)
+		}
+		if !names[ev.From] {
+			return fail("event %q: unknown state %q", ev.Name, ev.From)
+		} else if !names[to] {
+			return fail("event %q: unknown state %q", ev.Name, to)
+		}
+		if ev.After != "" {
+			if d, err := time.ParseDuration(ev.After); err != nil || d <= 0 {
+				return fail("event %q: invalid duration %q", ev.Name, ev.After)
+			} else if ev.Kind != "" && ev.Kind != "external" {
+				return fail("event %q: timed event must be external", ev.Name)
+			}
+		}
+		if _, ok := bd.hooks[ev.Hook]; ev.Hook != "" && !ok {
+			return fail("event %q: hook %q is not bound", ev.Name, ev.Hook)
+		}
+		if _, ok := bd.actions[ev.Action]; ev.Action != "" && !ok {
+			return fail("event %q: action %q is not bound", ev.Name, ev.Action)
+		}
+	}
+	for i := range spec.Regions {
+		rgWhere := fmt.Sprintf("%sregion %d/", where, i+1)
+		if err := bd.check(&spec.Regions[i], rgWhere); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// build register states and events of a checked specification recursively
+func (bd *Builder[O]) build(sm *StateMachine[O], spec *Spec) {
+	mode, _ := parseHistory(spec.History)
+	sm.SetHistoryMode(mode)
+	bnds := map[string]StateBinder[O, any]{}
+	finals := []StateID{}
+	for _, st := range spec.States {
+		bnd := RegState(sm, bd.states[st.Name].get(), st.Name)
+		bnds[st.Name] = bnd
+		if st.Final {
+			finals = append(finals, bnd.ID())
+		}
+		if st.Sub != nil {
+			bd.build(NewSubStateMachine(bnd), st.Sub)
+		}
+	}
+	for _, st := range spec.States {
+		if st.Initial {
+			sm.SetInitial(bnds[st.Name].ID())
+		}
+	}
+	sm.SetFinal(finals...)
+	for _, es := range spec.Events {
+		var ev EventX[O, any, any]
+		switch {
+		case es.Kind == "self":
+			ev = RegSelfEvent(sm, bnds[es.From], es.Name)
+		case es.Kind == "internal":
+			ev = RegInternalEvent(sm, bnds[es.From], es.Name)
+		case es.After != "":
+			d, _ := time.ParseDuration(es.After)
+			ev = RegTimedEvent(sm, bnds[es.From], bnds[es.To], d, es.Name)
+		default:
+			ev = RegEvent(sm, bnds[es.From], bnds[es.To], es.Name)
+		}
+		if es.Hook != "" {
+			ev.SetHookCtx(bd.hooks[es.Hook])
+		}
+		if es.Action != "" {
+			ev.SetAction(bd.actions[es.Action])
+		}
+	}
+	for i := range spec.Regions {
+		bd.build(NewRegion(sm), &spec.Regions[i])
+	}
+}
+
+// parseHistory parse history mode of specification
+func parseHistory(s string) (HistoryMode, bool) {
+	switch s {
+	case "", "none":
+		return HisNone, true
+	case "shallow":
+		return HisShallow, true
+	case "deep":
+		return HisDeep, true
+	}
+	return HisNone, false
+}
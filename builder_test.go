@@ -0,0 +1,184 @@
+package genesm
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// orderSpec is an example specification of an order flow
+const orderSpec = `{
+	"states": [
+		{"name": "cart"},
+		{"name": "paying", "sub": {
+			"history": "shallow",
+			"states": [{"name": "card"}, {"name": "bank"}],
+			"events": [{"name": "switch", "from": "card", "to": "bank"}]
+		}},
+		{"name": "shipped", "final": true}
+	],
+	"events": [
+		{"name": "checkout", "from": "cart", "to": "paying", "hook": "notEmpty"},
+		{"name": "cancel", "from": "paying", "to": "cart"},
+		{"name": "paid", "from": "paying", "to": "shipped", "action": "log"},
+		{"name": "touch", "from": "cart", "kind": "internal", "action": "log"}
+	]
+}`
+
+// orderBuilder create a Builder which bind all names of orderSpec. checkout
+// fail while items return 0. states selected by actions are appended to logs
+// if it is not nil
+func orderBuilder[O any](items func(O) int, logs *[]StateID) *Builder[O] {
+	return NewBuilder[O]().
+		BindState("cart", 0).
+		BindState("paying", "").
+		BindState("card", "visa").
+		BindState("bank", "swift").
+		BindState("shipped", nil).
+		BindHook("notEmpty", func(
+			ctx context.Context, owner O, a, b any,
+		) error {
+			if items(owner) == 0 {
+				return errors.New("empty cart")
+			}
+			return nil
+		}).
+		BindAction("log", func(owner O, a, b any, id StateID) {
+			if logs != nil {
+				*logs = append(*logs, id)
+			}
+		})
+}
+
+// itemsOf get count of items from owner which is the count itself
+func itemsOf(items int) int {
+	return items
+}
+
+func TestBuilder(t *testing.T) {
+	Convey("Build state machine from specification", t, func() {
+		spec, err := LoadSpec(strings.NewReader(orderSpec))
+		So(err, ShouldBeNil)
+		items := 0
+		logs := []StateID{}
+		bd := orderBuilder(func(string) int { return items }, &logs)
+		sm, err := bd.Build("owner", spec)
+		So(err, ShouldBeNil)
+		So(sm.Validate(), ShouldBeEmpty)
+
+		bndCart := LookupState[any](sm, "cart")
+		So(bndCart.Get(), ShouldEqual, 0)
+		ev := func(sm *StateMachine[string], name string) Event {
+			return LookupEvent[Event](sm, name)
+		}
+		So(ev(sm, "checkout").Trigger(), ShouldNotBeNil)
+		So(ev(sm, "touch").Trigger(), ShouldBeNil)
+		items = 1
+		So(ev(sm, "checkout").Trigger(), ShouldBeNil)
+		paying := LookupState[any](sm, "paying").SubMachine()
+		So(ev(paying, "switch").Trigger(), ShouldBeNil)
+		So(ev(sm, "cancel").Trigger(), ShouldBeNil)
+		So(ev(sm, "checkout").Trigger(), ShouldBeNil)
+		So(paying.Describe(paying.StateID()), ShouldEqual, "bank")
+		So(ev(sm, "paid").Trigger(), ShouldBeNil)
+		So(sm.IsFinished(), ShouldBeTrue)
+		So(logs, ShouldResemble, []StateID{
+			LookupState[any](sm, "cart").ID(),
+			LookupState[any](sm, "shipped").ID(),
+		})
+	})
+
+	Convey("Reject invalid specification", t, func() {
+		bd := NewBuilder[string]().BindState("a", 1).BindState("b", 2)
+		cases := []struct{ js, msg string }{
+			{`{"states": []}`, "no state"},
+			{`{"states": [{"name": "a"}, {"name": "c"}]}`,
+				`state "c" is not bound`},
+			{`{"states": [{"name": "a"}], "events": [
+				{"name": "e", "from": "a", "to": "b"}]}`,
+				`event "e": unknown state "b"`},
+			{`{"states": [{"name": "a"}, {"name": "b"}], "events": [
+				{"name": "e", "from": "a", "to": "b", "hook": "h"}]}`,
+				`hook "h" is not bound`},
+			{`{"states": [{"name": "a", "sub": {"states": [{"name": "x"}]}}]}`,
+				`a/state "x" is not bound`},
+			{`{"states": [{"name": "a"}, {"name": "b"}], "events": [
+				{"name": "e", "from": "a", "to": "b", "after": "soon"}]}`,
+				`invalid duration "soon"`},
+			{`{"states": [
+				{"name": "a", "initial": true}, {"name": "b", "initial": true}]}`,
+				"several initial states"},
+		}
+		for _, c := range cases {
+			spec, err := LoadSpec(strings.NewReader(c.js))
+			So(err, ShouldBeNil)
+			_, err = bd.Build("owner", spec)
+			So(errors.Is(err, ErrSpec), ShouldBeTrue)
+			So(err.Error(), ShouldContainSubstring, c.msg)
+			So(bd.Check(spec), ShouldResemble, err)
+		}
+		_, err := LoadSpec(strings.NewReader(`{"stats": []}`))
+		So(errors.Is(err, ErrSpec), ShouldBeTrue)
+	})
+	Convey("Persist built state machine", t, func() {
+		spec, err := LoadSpec(strings.NewReader(`{
+			"states": [{"name": "idle"}, {"name": "busy"}],
+			"events": [{"name": "start", "from": "idle", "to": "busy"}]
+		}`))
+		So(err, ShouldBeNil)
+		bd := NewBuilder[string]().
+			BindState("idle", 0).BindState("busy", profile{})
+		for _, codec := range []Codec{JSONCodec, GobCodec} {
+			src, err := bd.Build("owner", spec)
+			So(err, ShouldBeNil)
+			buf := &bytes.Buffer{}
+			src.SetJournal(buf, codec)
+			So(LookupState[any](src, "idle").Set(3), ShouldBeNil)
+			So(LookupState[any](src, "busy").Set(profile{"bob", 10}),
+				ShouldBeNil)
+			So(LookupEvent[Event](src, "start").Trigger(), ShouldBeNil)
+			data, err := src.Snapshot(codec)
+			So(err, ShouldBeNil)
+
+			// values keep their types
+			check := func(dst *StateMachine[string]) {
+				So(LookupState[any](dst, "idle").Get(), ShouldEqual, 3)
+				So(LookupState[any](dst, "busy").Get(), ShouldResemble,
+					profile{"bob", 10})
+				So(dst.StateID(), ShouldEqual,
+					LookupState[any](dst, "busy").ID())
+			}
+			dst, err := bd.Build("owner", spec)
+			So(err, ShouldBeNil)
+			So(dst.Restore(data, codec), ShouldBeNil)
+			check(dst)
+			dst, err = bd.Build("owner", spec)
+			So(err, ShouldBeNil)
+			So(dst.Replay(bytes.NewReader(buf.Bytes()), codec, true),
+				ShouldBeNil)
+			check(dst)
+		}
+	})
+
+	Convey("Bind value factory", t, func() {
+		spec, err := LoadSpec(strings.NewReader(`{
+			"states": [{"name": "a", "sub": {"states": [{"name": "a"}]}}]
+		}`))
+		So(err, ShouldBeNil)
+		bd := NewBuilder[string]().BindStateFunc("a", func() any {
+			return []int{}
+		})
+		sm, err := bd.Build("owner", spec)
+		So(err, ShouldBeNil)
+		bnd := LookupState[any](sm, "a")
+		So(bnd.Set(append(bnd.Get().([]int), 1)), ShouldBeNil)
+		So(LookupState[any](bnd.SubMachine(), "a").Get(), ShouldBeEmpty)
+		other, err := bd.Build("owner", spec)
+		So(err, ShouldBeNil)
+		So(LookupState[any](other, "a").Get(), ShouldBeEmpty)
+	})
+}
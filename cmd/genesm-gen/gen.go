@@ -0,0 +1,296 @@
+package main
+
+import (
+	"fmt"
+	"go/format"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/fiathux/genesm"
+)
+
+// genConfig is configuration of code generating
+type genConfig struct {
+	pkg     string
+	name    string
+	owner   string
+	imports []string
+}
+
+// genMachine describe a state machine in generated code
+type genMachine struct {
+	field  string // field of the state machine
+	prefix string // prefix of fields of its states and events
+	spec   *genesm.Spec
+}
+
+// generator generate code of a specification
+type generator struct {
+	cfg     genConfig
+	fields  strings.Builder // fields of struct
+	values  strings.Builder // fields of values struct
+	body    strings.Builder // body of constructor
+	access  strings.Builder // accessors
+	used    map[string]string
+	timeUse bool
+}
+
+// generate check the specification and generate formatted code
+func generate(cfg genConfig, spec *genesm.Spec) ([]byte, error) {
+	if err := checkSpec(spec); err != nil {
+		return nil, err
+	}
+	g := &generator{cfg: cfg, used: map[string]string{}}
+	if err := g.machine(genMachine{field: "SM", spec: spec}, "spec"); err != nil {
+		return nil, err
+	}
+	b := &strings.Builder{}
+	b.WriteString("// Code generated by genesm-gen. DO NOT EDIT.\n\n")
+	fmt.Fprintf(b, "package %s\n\n", cfg.pkg)
+	std, others := []string{}, []string{"github.com/fiathux/genesm"}
+	if g.timeUse {
+		std = append(std, "time")
+	}
+	for _, imp := range cfg.imports {
+		imp = strings.TrimSpace(imp)
+		if strings.Contains(strings.Split(imp, "/")[0], ".") {
+			others = append(others, imp)
+		} else {
+			std = append(std, imp)
+		}
+	}
+	b.WriteString("import (\n")
+	for _, grp := range [][]string{std, others} {
+		sort.Strings(grp)
+		for _, imp := range grp {
+			fmt.Fprintf(b, "\t%q\n", imp)
+		}
+		b.WriteString("\n")
+	}
+	b.WriteString(")\n\n")
+	fmt.Fprintf(b, "// %s is a typed state machine\n", cfg.name)
+	fmt.Fprintf(b, "type %s struct {\n", cfg.name)
+	fmt.Fprintf(b, "\tSM *genesm.StateMachine[%s]\n", cfg.owner)
+	b.WriteString(g.fields.String())
+	b.WriteString("}\n\n")
+	fmt.Fprintf(b, "// %sValues is initial values of states\n", cfg.name)
+	fmt.Fprintf(b, "type %sValues struct {\n", cfg.name)
+	b.WriteString(g.values.String())
+	b.WriteString("}\n\n")
+	fmt.Fprintf(b,
+		"// New%s create %s. all states and events are registered\n",
+		cfg.name, cfg.name)
+	fmt.Fprintf(b, "func New%s(owner %s, vals %sValues) *%s {\n",
+		cfg.name, cfg.owner, cfg.name, cfg.name)
+	fmt.Fprintf(b, "\tm := &%s{SM: genesm.NewStateMachine(owner)}\n", cfg.name)
+	b.WriteString(g.body.String())
+	b.WriteString("\treturn m\n}\n")
+	b.WriteString(g.access.String())
+	code, err := format.Source([]byte(b.String()))
+	if err != nil {
+		return nil, fmt.Errorf("format generated code: %v", err)
+	}
+	return code, nil
+}
+
+// checkSpec check specification by a Builder with all names bound
+func checkSpec(spec *genesm.Spec) error {
+	bd := genesm.NewBuilder[any]()
+	var bind func(spec *genesm.Spec)
+	bind = func(spec *genesm.Spec) {
+		for _, st := range spec.States {
+			bd.BindState(st.Name, nil)
+			if st.Sub != nil {
+				bind(st.Sub)
+			}
+		}
+		for _, ev := range spec.Events {
+			bd.BindHook(ev.Hook, nil)
+			bd.BindAction(ev.Action, nil)
+		}
+		for i := range spec.Regions {
+			bind(&spec.Regions[i])
+		}
+	}
+	bind(spec)
+	return bd.Check(spec)
+}
+
+// ident convert a name to exported Go identifier
+func ident(name string) string {
+	b := &strings.Builder{}
+	upper := true
+	for _, r := range name {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			upper = true
+			continue
+		}
+		if upper {
+			r = unicode.ToUpper(r)
+			upper = false
+		}
+		b.WriteRune(r)
+	}
+	ret := b.String()
+	if ret == "" || !unicode.IsLetter([]rune(ret)[0]) {
+		ret = "S" + ret
+	}
+	return ret
+}
+
+// use occupy a field name. it fail if the name is used by another one
+func (g *generator) use(field, what string) error {
+	if prev, ok := g.used[field]; ok {
+		return fmt.Errorf("%s and %s have the same field name %s",
+			prev, what, field)
+	}
+	g.used[field] = what
+	return nil
+}
+
+// machine generate registrations of a state machine recursively
+func (g *generator) machine(gm genMachine, where string) error {
+	owner := g.cfg.owner
+	types := map[string]string{}
+	finals := []string{}
+	for _, st := range gm.spec.States {
+		field := gm.prefix + ident(st.Name)
+		what := fmt.Sprintf("state %q of %s", st.Name, where)
+		if err := g.use(field, what); err != nil {
+			return err
+		}
+		typ := st.Type
+		if typ == "" {
+			typ = "any"
+		}
+		types[st.Name] = typ
+		fmt.Fprintf(&g.fields, "\t%s genesm.StateBinder[%s, %s]\n",
+			field, owner, typ)
+		fmt.Fprintf(&g.values, "\t%s %s\n", field, typ)
+		fmt.Fprintf(&g.body, "\tm.%s = genesm.RegState(m.%s, vals.%s, %q)\n",
+			field, gm.field, field, st.Name)
+		fmt.Fprintf(&g.access,
+			"\n// Is%s check whether state %q is selected\n", field, st.Name)
+		fmt.Fprintf(&g.access, "func (m *%s) Is%s() bool {\n", g.cfg.name, field)
+		fmt.Fprintf(&g.access, "\treturn m.%s.IsSelected()\n}\n", field)
+		if st.Final {
+			finals = append(finals, "m."+field+".ID()")
+		}
+		if st.Sub == nil {
+			continue
+		}
+		sub := genMachine{field: field + "SM", prefix: field, spec: st.Sub}
+		if err := g.use(sub.field, "sub state machine of "+what); err != nil {
+			return err
+		}
+		fmt.Fprintf(&g.fields, "\t%s *genesm.StateMachine[%s]\n",
+			sub.field, owner)
+		fmt.Fprintf(&g.body, "\tm.%s = genesm.NewSubStateMachine(m.%s)\n",
+			sub.field, field)
+		if err := g.machine(sub, what); err != nil {
+			return err
+		}
+	}
+	switch gm.spec.History {
+	case "shallow":
+		fmt.Fprintf(&g.body, "\tm.%s.SetHistoryMode(genesm.HisShallow)\n",
+			gm.field)
+	case "deep":
+		fmt.Fprintf(&g.body, "\tm.%s.SetHistoryMode(genesm.HisDeep)\n",
+			gm.field)
+	}
+	for _, st := range gm.spec.States {
+		if st.Initial {
+			fmt.Fprintf(&g.body, "\tm.%s.SetInitial(m.%s.ID())\n",
+				gm.field, gm.prefix+ident(st.Name))
+		}
+	}
+	if len(finals) != 0 {
+		fmt.Fprintf(&g.body, "\tm.%s.SetFinal(%s)\n",
+			gm.field, strings.Join(finals, ", "))
+	}
+	for _, ev := range gm.spec.Events {
+		if err := g.event(gm, ev, types, where); err != nil {
+			return err
+		}
+	}
+	for i := range gm.spec.Regions {
+		rg := genMachine{
+			field: fmt.Sprintf("%sRegion%d", gm.prefix, i+1),
+			spec:  &gm.spec.Regions[i],
+		}
+		rg.prefix = rg.field
+		rg.field += "SM"
+		what := fmt.Sprintf("region %d of %s", i+1, where)
+		if err := g.use(rg.field, what); err != nil {
+			return err
+		}
+		fmt.Fprintf(&g.fields, "\t%s *genesm.StateMachine[%s]\n",
+			rg.field, owner)
+		fmt.Fprintf(&g.body, "\tm.%s = genesm.NewRegion(m.%s)\n",
+			rg.field, gm.field)
+		if err := g.machine(rg, what); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// event generate registration of an event
+func (g *generator) event(
+	gm genMachine, ev genesm.EventSpec, types map[string]string, where string,
+) error {
+	field := gm.prefix + ident(ev.Name)
+	err := g.use(field, fmt.Sprintf("event %q of %s", ev.Name, where))
+	if err != nil {
+		return err
+	}
+	to := ev.To
+	if to == "" {
+		to = ev.From
+	}
+	from, dst := gm.prefix+ident(ev.From), gm.prefix+ident(to)
+	fmt.Fprintf(&g.fields, "\t%s genesm.EventX[%s, %s, %s]\n",
+		field, g.cfg.owner, types[ev.From], types[to])
+	switch {
+	case ev.Kind == "self":
+		fmt.Fprintf(&g.body, "\tm.%s = genesm.RegSelfEvent(m.%s, m.%s, %q)\n",
+			field, gm.field, from, ev.Name)
+	case ev.Kind == "internal":
+		fmt.Fprintf(&g.body,
+			"\tm.%s = genesm.RegInternalEvent(m.%s, m.%s, %q)\n",
+			field, gm.field, from, ev.Name)
+	case ev.After != "":
+		d, _ := time.ParseDuration(ev.After)
+		g.timeUse = true
+		fmt.Fprintf(&g.body,
+			"\tm.%s = genesm.RegTimedEvent(m.%s, m.%s, m.%s, %s, %q)\n",
+			field, gm.field, from, dst, durationExpr(d), ev.Name)
+	default:
+		fmt.Fprintf(&g.body, "\tm.%s = genesm.RegEvent(m.%s, m.%s, m.%s, %q)\n",
+			field, gm.field, from, dst, ev.Name)
+	}
+	return nil
+}
+
+// durationExpr convert a duration to Go expression
+func durationExpr(d time.Duration) string {
+	units := []struct {
+		d    time.Duration
+		name string
+	}{
+		{time.Hour, "time.Hour"},
+		{time.Minute, "time.Minute"},
+		{time.Second, "time.Second"},
+		{time.Millisecond, "time.Millisecond"},
+		{time.Microsecond, "time.Microsecond"},
+	}
+	for _, u := range units {
+		if d%u.d == 0 {
+			return fmt.Sprintf("%d * %s", d/u.d, u.name)
+		}
+	}
+	return fmt.Sprintf("time.Duration(%d)", int64(d))
+}
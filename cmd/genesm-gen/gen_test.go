@@ -0,0 +1,132 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/fiathux/genesm"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// orderSpec is an example specification with typed states
+const orderSpec = `{
+	"states": [
+		{"name": "cart", "type": "int"},
+		{"name": "paying", "type": "string", "sub": {
+			"history": "shallow",
+			"states": [{"name": "card"}, {"name": "bank-transfer"}],
+			"events": [{"name": "switch", "from": "card", "to": "bank-transfer"}]
+		}},
+		{"name": "shipped", "type": "*Parcel", "final": true}
+	],
+	"events": [
+		{"name": "checkout", "from": "cart", "to": "paying", "hook": "notEmpty"},
+		{"name": "paid", "from": "paying", "to": "shipped"},
+		{"name": "expire", "from": "paying", "to": "cart", "after": "90s"},
+		{"name": "touch", "from": "cart", "kind": "internal"}
+	]
+}`
+
+func TestGenerate(t *testing.T) {
+	cfg := genConfig{pkg: "order", name: "Order", owner: "*Session"}
+
+	Convey("Generate typed state machine", t, func() {
+		spec, err := genesm.LoadSpec(strings.NewReader(orderSpec))
+		So(err, ShouldBeNil)
+		code, err := generate(cfg, spec)
+		So(err, ShouldBeNil)
+		src := string(code)
+		flat := strings.Join(strings.Fields(src), " ")
+		So(src, ShouldStartWith, "// Code generated by genesm-gen. DO NOT EDIT.")
+		So(src, ShouldContainSubstring, "package order")
+		So(src, ShouldContainSubstring, `"time"`)
+		for _, s := range []string{
+			"Cart genesm.StateBinder[*Session, int]",
+			"PayingSM        *genesm.StateMachine[*Session]",
+			"PayingCard genesm.StateBinder[*Session, any]",
+			"Shipped genesm.StateBinder[*Session, *Parcel]",
+			"PayingSwitch genesm.EventX[*Session, any, any]",
+			"Checkout genesm.EventX[*Session, int, string]",
+			"Touch genesm.EventX[*Session, int, int]",
+			`m.Cart = genesm.RegState(m.SM, vals.Cart, "cart")`,
+			"m.PayingSM = genesm.NewSubStateMachine(m.Paying)",
+			"m.PayingSM.SetHistoryMode(genesm.HisShallow)",
+			"m.SM.SetFinal(m.Shipped.ID())",
+			`genesm.RegTimedEvent(m.SM, m.Paying, m.Cart, 90*time.Second, "expire")`,
+			`genesm.RegInternalEvent(m.SM, m.Cart, "touch")`,
+			"func (m *Order) IsPayingBankTransfer() bool {",
+		} {
+			s = strings.Join(strings.Fields(s), " ")
+			So(flat, ShouldContainSubstring, s)
+		}
+	})
+
+	Convey("Reject specification which can not be generated", t, func() {
+		cases := []struct{ js, msg string }{
+			{`{"states": [{"name": "a"}], "events": [
+				{"name": "e", "from": "a", "to": "b"}]}`,
+				`unknown state "b"`},
+			{`{"states": [{"name": "a-b"}, {"name": "a_b"}]}`,
+				"the same field name AB"},
+			{`{"states": [{"name": "a"}, {"name": "b"}], "events": [
+				{"name": "a", "from": "a", "to": "b"}]}`,
+				"the same field name A"},
+		}
+		for _, c := range cases {
+			spec, err := genesm.LoadSpec(strings.NewReader(c.js))
+			So(err, ShouldBeNil)
+			_, err = generate(cfg, spec)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, c.msg)
+		}
+	})
+}
+
+// orderTypes declare types which used by generated code of orderSpec
+const orderTypes = `package order
+
+type Session struct{}
+
+type Parcel struct{}
+
+func newOrder() *Order {
+	return NewOrder(&Session{}, OrderValues{Cart: 1, Shipped: &Parcel{}})
+}
+`
+
+func TestGenerateCompile(t *testing.T) {
+	gotool, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go tool is not available")
+	}
+	cfg := genConfig{pkg: "order", name: "Order", owner: "*Session"}
+
+	Convey("Generated code could be compiled", t, func() {
+		spec, err := genesm.LoadSpec(strings.NewReader(orderSpec))
+		So(err, ShouldBeNil)
+		code, err := generate(cfg, spec)
+		So(err, ShouldBeNil)
+		// a module outside of the tree which resolve genesm to the repository
+		root, err := filepath.Abs(filepath.Join("..", ".."))
+		So(err, ShouldBeNil)
+		dir := t.TempDir()
+		mod := "module order\n\ngo 1.19\n\n" +
+			"require github.com/fiathux/genesm v0.0.0\n\n" +
+			"replace github.com/fiathux/genesm => " + root + "\n"
+		So(os.WriteFile(filepath.Join(dir, "go.mod"), []byte(mod), 0o644),
+			ShouldBeNil)
+		So(os.WriteFile(filepath.Join(dir, "order_gen.go"), code, 0o644),
+			ShouldBeNil)
+		So(os.WriteFile(filepath.Join(dir, "types.go"), []byte(orderTypes),
+			0o644), ShouldBeNil)
+		cmd := exec.Command(gotool, "vet", "./...")
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(), "GOWORK=off")
+		out, err := cmd.CombinedOutput()
+		So(string(out), ShouldBeEmpty)
+		So(err, ShouldBeNil)
+	})
+}
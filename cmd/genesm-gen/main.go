@@ -0,0 +1,70 @@
+// genesm-gen generate a strongly typed state machine from a JSON
+// specification (see genesm.Spec).
+//
+// the generated code contain a struct with one field per state, sub state
+// machine, region and event, a constructor which do all registrations, and
+// accessors to check selected state. hooks and actions in specification are
+// not generated, set them on the event fields instead. usage:
+//
+//	genesm-gen -spec order.json -pkg order -name Order -owner '*Session' \
+//	    -o order_gen.go
+//
+// it is designed to be used in go:generate, such as:
+//
+//	//go:generate genesm-gen -spec order.json -pkg order -name Order
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/fiathux/genesm"
+)
+
+func main() {
+	cfg := genConfig{}
+	specFile := flag.String("spec", "", "path of JSON specification")
+	output := flag.String("o", "", "output file. default is stdout")
+	imports := flag.String("imports", "",
+		"comma separated import paths which types in specification need")
+	flag.StringVar(&cfg.pkg, "pkg", "main", "package name of generated code")
+	flag.StringVar(&cfg.name, "name", "Machine", "name of generated struct")
+	flag.StringVar(&cfg.owner, "owner", "any", "type of owner")
+	flag.Parse()
+	if *specFile == "" {
+		fmt.Fprintln(os.Stderr, "genesm-gen: -spec is required")
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *imports != "" {
+		cfg.imports = strings.Split(*imports, ",")
+	}
+	if err := run(cfg, *specFile, *output); err != nil {
+		fmt.Fprintln(os.Stderr, "genesm-gen:", err)
+		os.Exit(1)
+	}
+}
+
+// run load specification and write generated code
+func run(cfg genConfig, specFile, output string) error {
+	f, err := os.Open(specFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	spec, err := genesm.LoadSpec(f)
+	if err != nil {
+		return err
+	}
+	code, err := generate(cfg, spec)
+	if err != nil {
+		return err
+	}
+	if output == "" {
+		_, err = os.Stdout.Write(code)
+		return err
+	}
+	return os.WriteFile(output, code, 0644)
+}
@@ -1,33 +1,145 @@
 package genesm
 
-import "errors"
+import (
+	"context"
+	"errors"
+	"time"
+)
 
 // Event errors
 var (
 	ErrEvEmptyGroup   = errors.New("no member in event group")
 	ErrEvGroupFailure = errors.New("all events are failure")
+
+	errEvStale = errors.New("stale timed event") // never be recorded
 )
 
+// EventID is serial number to identify a registed event
+type EventID struct {
+	SMSerial  uint32
+	RegSerial int
+}
+
+// EvIDInvalid return a EventID which represent invalid event
+func EvIDInvalid() EventID {
+	return EventID{
+		SMSerial:  0,
+		RegSerial: -1,
+	}
+}
+
+// IsInvalid check whether EventID is invalid
+func (e EventID) IsInvalid() bool {
+	return e.RegSerial < 0 || e.SMSerial == 0
+}
+
 // Event represent a event to change state on state matchine
+//
+// TriggerCtx is similar as Trigger, but it give up and return error of ctx if
+// ctx is done before state machine can be acquired, or observer events can be
+// queued. in the later case the transition is already done, but rest observer
+// events are dropped.
 type Event interface {
 	Trigger() error
+	TriggerCtx(ctx context.Context) error
 }
 
 // EventX represent a event that similar as Event. but which allow you add hook
-// ahead the event trigger
+// ahead the event trigger.
+//
+// SetHookCtx is similar as SetHook. but hook receive context which passed to
+// TriggerCtx. only one hook is kept, the later set one replace previous one.
+//
+// SetAction set an action which run after the transition is done. unlike the
+// hook, it can not cancel the event. it is run under protected of state
+// machine, after observers of both states are notified.
+//
+// ID get the EventID which assigned by StateMachine on registing.
 type EventX[O any, A any, B any] interface {
 	Event
+	ID() EventID
 	SetHook(hook func(O, A, B) error)
+	SetHookCtx(hook func(context.Context, O, A, B) error)
+	SetAction(action func(O, A, B, StateID))
+}
+
+// EventP represent a event which carry a payload. the payload is passed to the
+// hook and delivered to observers of both states on transition (see
+// ObsHandlerPayload).
+type EventP[O any, A any, B any, P any] interface {
+	ID() EventID
+	Trigger(payload P) error
+	TriggerCtx(ctx context.Context, payload P) error
+	SetHook(hook func(O, A, B, P) error)
+	SetHookCtx(hook func(context.Context, O, A, B, P) error)
+	SetAction(action func(O, A, B, P, StateID))
 }
 
 // eventBind implement a Event. it will regist to StateMachine. then provide
 // methods to hook or trigger state change.
 type eventBind[O any, A any, B any] struct {
-	sm *StateMachine[O]
-	a  StateBinder[O, A]
-	b  StateBinder[O, B]
+	id    EventID
+	name  string
+	sm    *StateMachine[O]
+	a     StateBinder[O, A]
+	b     StateBinder[O, B]
+	kind  transKind
+	dwell time.Duration // dwell time of timed event
 
-	hook func(O, A, B) error
+	hook   func(context.Context, O, A, B) error
+	action func(O, A, B, StateID)
+
+	// replayer replay the event with encoded payload. nil means the event
+	// carry no payload
+	replayer func(codec Codec, data []byte) error
+}
+
+// anyEventBind implement a EventX which source is any state. value of source
+// state is passed to hook and action in type-erased form
+type anyEventBind[O any, B any] struct {
+	id     EventID
+	name   string
+	sm     *StateMachine[O]
+	b      StateBinder[O, B]
+	except map[int]bool // register serials of excluded source states
+
+	hook   func(context.Context, O, any, B) error
+	action func(O, any, B, StateID)
+}
+
+// payloadEventBind implement a EventP.
+type payloadEventBind[O any, A any, B any, P any] struct {
+	*eventBind[O, A, B]
+	hook   func(context.Context, O, A, B, P) error
+	action func(O, A, B, P, StateID)
+}
+
+// A eventAgent provide inner methods for StateMachine to interact with
+// registed events
+type eventAgent interface {
+	replay(codec Codec, data []byte) error
+	info() EventInfo
+	edges() []EventInfo
+	joinGroup(members []EventID)
+}
+
+// EventKind represent kind of a registed event
+type EventKind int
+
+const (
+	EvExternal EventKind = iota // change to another state (RegEvent)
+	EvSelf                      // exit and re-enter state (RegSelfEvent)
+	EvInternal                  // keep state (RegInternalEvent)
+)
+
+// EventInfo describe a registed event. which is an edge of state graph
+type EventInfo struct {
+	ID    EventID
+	Name  string
+	From  StateID
+	To    StateID
+	Kind  EventKind
+	Dwell time.Duration // dwell time of timed event. 0 for others
 }
 
 // eventGroup group several Event objects
@@ -38,25 +150,180 @@ type eventGroup []Event
 // A event rule is path to change state from one (a) to next one (b).
 //
 // it return Event interface to let developer to trigger it.
+//
+// if a and b are the same state, trigger the event always return
+// ErrEvNothingTodo. use RegSelfEvent or RegInternalEvent for this case.
+//
+// an optional name could be given to the event. it must be unique on the state
+// machine. see LookupEvent and StateMachine.DescribeEvent. other Reg*Event
+// functions accept name as well.
 func RegEvent[O any, A any, B any](
 	sm *StateMachine[O], a StateBinder[O, A], b StateBinder[O, B],
+	name ...string,
+) EventX[O, A, B] {
+	mustOwned(sm, a.Parent(), "a")
+	mustOwned(sm, b.Parent(), "b")
+	return newEventBind(sm, a, b, name)
+}
+
+// RegSelfEvent regist a self-transition event rule on state (a) to state
+// machine.
+//
+// once it is triggered, state (a) is exited and then entered again. observers
+// of the state will receive both exit and enter. if the state have sub state
+// machine, the sub state machine is restarted as well.
+func RegSelfEvent[O any, A any](
+	sm *StateMachine[O], a StateBinder[O, A], name ...string,
+) EventX[O, A, A] {
+	mustOwned(sm, a.Parent(), "a")
+	eb := newEventBind(sm, a, a, name)
+	eb.kind = trsSelf
+	return eb
+}
+
+// RegInternalEvent regist an internal transition event rule on state (a) to
+// state machine.
+//
+// once it is triggered, only hook and action of the event are run. the state
+// is not exited, so observers receive nothing.
+func RegInternalEvent[O any, A any](
+	sm *StateMachine[O], a StateBinder[O, A], name ...string,
+) EventX[O, A, A] {
+	mustOwned(sm, a.Parent(), "a")
+	eb := newEventBind(sm, a, a, name)
+	eb.kind = trsInternal
+	return eb
+}
+
+// RegTimedEvent regist a timed event rule to state machine.
+//
+// it is similar as RegEvent. but the event is triggered by itself once state
+// (a) have been selected for dwell time. the timer is cancelled if state (a)
+// is left earlier, and restarted on each entering (self-transition as well).
+// a timer which fire late never change state for a later selection of (a).
+//
+// hook and action are applied to automatic trigger as well. error of the
+// automatic trigger is discarded. it is still allowed to trigger the event
+// manually.
+func RegTimedEvent[O any, A any, B any](
+	sm *StateMachine[O], a StateBinder[O, A], b StateBinder[O, B],
+	dwell time.Duration, name ...string,
 ) EventX[O, A, B] {
-	if a.Parent() != sm {
-		panic("state (a) is not be owned under specified StateMachine")
+	mustOwned(sm, a.Parent(), "a")
+	mustOwned(sm, b.Parent(), "b")
+	eb := newEventBind(sm, a, b, name)
+	eb.dwell = dwell
+	sm.addTimer(a.ID().RegSerial, dwell,
+		func(ctx context.Context, check func() error) error {
+			return eb.trigger(ctx, nil, check, eb.runAction)
+		}, eb.check)
+	return eb
+}
+
+// RegAnyEvent regist an event rule from any state to state (b). it is useful
+// for events such as "abort" which should work on every state. the states in
+// except are excluded from sources. states registed later are sources as well.
+//
+// hook and action receive value of the source state in type-erased form. the
+// event return ErrEvUnexpectedState if it is triggered on an excluded state,
+// and ErrEvAlreadyChanged on state (b).
+//
+// for introspection and exporting, the event is expanded as an edge from each
+// source state.
+func RegAnyEvent[O any, B any](
+	sm *StateMachine[O], b StateBinder[O, B], except []StateID,
+	name ...string,
+) EventX[O, any, B] {
+	mustOwned(sm, b.Parent(), "b")
+	ae := &anyEventBind[O, B]{
+		sm:     sm,
+		b:      b,
+		except: map[int]bool{},
 	}
-	if b.Parent() != sm {
-		panic("state (b) is not be owned under specified StateMachine")
+	for _, id := range except {
+		if id.SMSerial != sm.smSerial {
+			panic("excluded state is not be owned under specified " +
+				"StateMachine")
+		}
+		ae.except[id.RegSerial] = true
+	}
+	ae.name = reserveName(sm, true, name)
+	sm.regEvent(func(id EventID) eventAgent {
+		ae.id = id
+		return ae
+	})
+	bindEventName(sm, ae.id, ae.name, ae)
+	return ae
+}
+
+// mustOwned panic if a state is not owned by specified state machine
+func mustOwned[O any](sm, parent *StateMachine[O], name string) {
+	if parent != sm {
+		panic("state (" + name +
+			") is not be owned under specified StateMachine")
 	}
-	return &eventBind[O, A, B]{
-		sm: sm,
-		a:  a,
-		b:  b,
+}
+
+// newEventBind create a eventBind. the event is named if name is given
+func newEventBind[O any, A any, B any](
+	sm *StateMachine[O], a StateBinder[O, A], b StateBinder[O, B],
+	name []string,
+) *eventBind[O, A, B] {
+	nm := reserveName(sm, true, name)
+	eb := &eventBind[O, A, B]{
+		name: nm,
+		sm:   sm,
+		a:    a,
+		b:    b,
 	}
+	sm.regEvent(func(id EventID) eventAgent {
+		eb.id = id
+		return eb
+	})
+	bindEventName(sm, eb.id, nm, eb)
+	return eb
+}
+
+// RegPayloadEvent regist an event rule which carry payload to state machine
+//
+// it is similar as RegEvent. type of payload need be specified explicitly, such
+// as:
+//
+//	ev := RegPayloadEvent[MyPayload](sm, a, b)
+func RegPayloadEvent[P any, O any, A any, B any](
+	sm *StateMachine[O], a StateBinder[O, A], b StateBinder[O, B],
+	name ...string,
+) EventP[O, A, B, P] {
+	mustOwned(sm, a.Parent(), "a")
+	mustOwned(sm, b.Parent(), "b")
+	pe := &payloadEventBind[O, A, B, P]{
+		eventBind: newEventBind(sm, a, b, name),
+	}
+	pe.eventBind.replayer = pe.replay
+	bindEventName(sm, pe.id, pe.eventBind.name, pe)
+	return pe
 }
 
 // GroupEvent group several Event objects as a new Event. trigger this group is
-// equal to try in-order trigger each event until got a succeed
+// equal to try in-order trigger each event until got a succeed.
+//
+// the group is remembered by state machines of its members. so exporters can
+// show it as grouped edges.
+//
+// in run-to-completion mode, if a member is deferred, the group stop trying
+// and return ErrEvDeferred.
 func GroupEvent(evs ...Event) Event {
+	members := []EventID{}
+	for _, ev := range evs {
+		if ea, ok := ev.(eventAgent); ok {
+			members = append(members, ea.info().ID)
+		}
+	}
+	for _, ev := range evs {
+		if ea, ok := ev.(eventAgent); ok {
+			ea.joinGroup(members)
+		}
+	}
 	return eventGroup(evs)
 }
 
@@ -64,41 +331,314 @@ func GroupEvent(evs ...Event) Event {
 // State. if an error is be returned, event will be canceled as well.
 func (eb *eventBind[O, A, B]) SetHook(
 	hook func(O, A, B) error,
+) {
+	if hook == nil {
+		eb.hook = nil
+		return
+	}
+	eb.hook = func(_ context.Context, o O, a A, b B) error {
+		return hook(o, a, b)
+	}
+}
+
+// SetHookCtx set a hook function which receive context of trigger
+func (eb *eventBind[O, A, B]) SetHookCtx(
+	hook func(context.Context, O, A, B) error,
 ) {
 	eb.hook = hook
 }
 
+// SetAction set an action function which run after observers of both states
+// are notified (onExit and onEnter are done). it is still run under protected
+// of state machine. so it is safe to update bookkeeping of owner atomically
+// with the transition.
+//
+// the action receive owner, value of both states and the new state ID.
+func (eb *eventBind[O, A, B]) SetAction(action func(O, A, B, StateID)) {
+	eb.action = action
+}
+
+// ID get ID of the event
+func (eb *eventBind[O, A, B]) ID() EventID {
+	return eb.id
+}
+
 // Trigger trigger the event
-func (eb *eventBind[O, A, B]) Trigger() (rerr error) {
-	eb.sm.transform(func(curID StateID) StateID {
-		if curID != eb.a.ID() {
-			if curID == eb.b.ID() {
-				rerr = ErrEvAlreadyChanged
-			} else {
-				rerr = ErrEvUnexpectedState
+func (eb *eventBind[O, A, B]) Trigger() error {
+	return eb.TriggerCtx(context.Background())
+}
+
+// TriggerCtx trigger the event with context
+func (eb *eventBind[O, A, B]) TriggerCtx(ctx context.Context) error {
+	return eb.trigger(ctx, nil, func() error {
+		return eb.check(ctx)
+	}, eb.runAction)
+}
+
+// check run hook of event
+func (eb *eventBind[O, A, B]) check(ctx context.Context) error {
+	if eb.hook != nil {
+		return eb.hook(ctx, eb.sm.owner, eb.a.Get(), eb.b.Get())
+	}
+	return nil
+}
+
+// runAction run action of event
+func (eb *eventBind[O, A, B]) runAction(id StateID) {
+	if eb.action != nil {
+		eb.action(eb.sm.owner, eb.a.Get(), eb.b.Get(), id)
+	}
+}
+
+// replay implement eventAgent. it trigger the event with payload which is
+// decoded from data
+func (eb *eventBind[O, A, B]) replay(codec Codec, data []byte) error {
+	if eb.replayer != nil {
+		return eb.replayer(codec, data)
+	}
+	return eb.Trigger()
+}
+
+// edges implement eventAgent
+func (eb *eventBind[O, A, B]) edges() []EventInfo {
+	return []EventInfo{eb.info()}
+}
+
+// info implement eventAgent
+func (eb *eventBind[O, A, B]) info() EventInfo {
+	return EventInfo{
+		ID:    eb.id,
+		Name:  eb.name,
+		From:  eb.a.ID(),
+		To:    eb.b.ID(),
+		Kind:  EventKind(eb.kind),
+		Dwell: eb.dwell,
+	}
+}
+
+// joinGroup implement eventAgent. the group is remembered by state machine
+func (eb *eventBind[O, A, B]) joinGroup(members []EventID) {
+	eb.sm.addGroup(members)
+}
+
+// trigger do transform with payload. check is run under protected of state
+// machine after current state is checked. if it return an error, the event
+// will be canceled. action is run after transition is done
+func (eb *eventBind[O, A, B]) trigger(
+	ctx context.Context, payload any, check func() error, action func(StateID),
+) error {
+	return eb.sm.transform(eventTransition(
+		eb.kind, eb.id, eb.a.ID(), eb.b.ID(), ctx, payload, check, action))
+}
+
+// eventTransition build transition of an event from state a to b. check is
+// run after current state is checked
+func eventTransition(
+	kind transKind, id EventID, a, b StateID,
+	ctx context.Context, payload any, check func() error, action func(StateID),
+) transition {
+	return transition{
+		kind:   kind,
+		event:  id,
+		target: b,
+		next: func(curID StateID) (StateID, error) {
+			if curID != a {
+				if curID == b {
+					return STIDInvalid(), ErrEvAlreadyChanged
+				}
+				return STIDInvalid(), ErrEvUnexpectedState
 			}
-			return STIDInvalid()
-		}
-		if eb.hook != nil {
-			rerr = eb.hook(eb.sm.owner, eb.a.Get(), eb.b.Get())
+			if err := check(); err != nil {
+				return STIDInvalid(), err
+			}
+			return b, nil
+		},
+		ctx:     ctx,
+		payload: payload,
+		action:  action,
+	}
+}
+
+// SetHook set a hook function that allow developer check contain data of each
+// State and the payload. if an error is be returned, event will be canceled
+// as well.
+func (pe *payloadEventBind[O, A, B, P]) SetHook(
+	hook func(O, A, B, P) error,
+) {
+	if hook == nil {
+		pe.hook = nil
+		return
+	}
+	pe.hook = func(_ context.Context, o O, a A, b B, p P) error {
+		return hook(o, a, b, p)
+	}
+}
+
+// SetHookCtx set a hook function which receive context of trigger
+func (pe *payloadEventBind[O, A, B, P]) SetHookCtx(
+	hook func(context.Context, O, A, B, P) error,
+) {
+	pe.hook = hook
+}
+
+// SetAction set an action function which run after the transition is done.
+// it is similar as EventX.SetAction, but also receive the payload
+func (pe *payloadEventBind[O, A, B, P]) SetAction(
+	action func(O, A, B, P, StateID),
+) {
+	pe.action = action
+}
+
+// Trigger trigger the event with payload
+func (pe *payloadEventBind[O, A, B, P]) Trigger(payload P) error {
+	return pe.TriggerCtx(context.Background(), payload)
+}
+
+// TriggerCtx trigger the event with context and payload
+func (pe *payloadEventBind[O, A, B, P]) TriggerCtx(
+	ctx context.Context, payload P,
+) error {
+	return pe.trigger(ctx, payload, func() error {
+		if pe.hook != nil {
+			return pe.hook(ctx, pe.sm.owner, pe.a.Get(), pe.b.Get(), payload)
 		}
-		if rerr != nil {
-			return STIDInvalid()
+		return nil
+	}, func(id StateID) {
+		if pe.action != nil {
+			pe.action(pe.sm.owner, pe.a.Get(), pe.b.Get(), payload, id)
 		}
-		return eb.b.ID()
 	})
-	return
+}
+
+// replay decode payload and trigger the event
+func (pe *payloadEventBind[O, A, B, P]) replay(codec Codec, data []byte) error {
+	var payload P
+	if data != nil {
+		if err := codec.Unmarshal(data, &payload); err != nil {
+			return err
+		}
+	}
+	return pe.Trigger(payload)
 }
 
 // Trigger try in-order trigger each event
-func (eg eventGroup) Trigger() (rerr error) {
+func (eg eventGroup) Trigger() error {
+	return eg.TriggerCtx(context.Background())
+}
+
+// TriggerCtx try in-order trigger each event with context. it stop trying once
+// ctx is done
+func (eg eventGroup) TriggerCtx(ctx context.Context) error {
 	if len(eg) == 0 {
 		return ErrEvEmptyGroup
 	}
 	for _, ev := range eg {
-		if err := ev.Trigger(); err == nil {
-			return nil
+		if err := ev.TriggerCtx(ctx); err == nil || err == ErrEvDeferred {
+			return err
+		} else if cerr := ctx.Err(); cerr != nil {
+			return cerr
 		}
 	}
 	return ErrEvGroupFailure
 }
+
+// SetHook set a hook function which receive value of current state
+func (ae *anyEventBind[O, B]) SetHook(hook func(O, any, B) error) {
+	if hook == nil {
+		ae.hook = nil
+		return
+	}
+	ae.hook = func(_ context.Context, o O, a any, b B) error {
+		return hook(o, a, b)
+	}
+}
+
+// SetHookCtx set a hook function which receive context of trigger
+func (ae *anyEventBind[O, B]) SetHookCtx(
+	hook func(context.Context, O, any, B) error,
+) {
+	ae.hook = hook
+}
+
+// SetAction set an action function which run after the transition is done.
+// it receive value of the source state
+func (ae *anyEventBind[O, B]) SetAction(action func(O, any, B, StateID)) {
+	ae.action = action
+}
+
+// ID get ID of the event
+func (ae *anyEventBind[O, B]) ID() EventID {
+	return ae.id
+}
+
+// Trigger trigger the event
+func (ae *anyEventBind[O, B]) Trigger() error {
+	return ae.TriggerCtx(context.Background())
+}
+
+// TriggerCtx trigger the event with context
+func (ae *anyEventBind[O, B]) TriggerCtx(ctx context.Context) error {
+	var src any // value of source state
+	return ae.sm.transform(transition{
+		kind:   trsExternal,
+		event:  ae.id,
+		target: ae.b.ID(),
+		next: func(curID StateID) (StateID, error) {
+			if curID == ae.b.ID() {
+				return STIDInvalid(), ErrEvAlreadyChanged
+			} else if curID.IsInvalid() || ae.except[curID.RegSerial] {
+				return STIDInvalid(), ErrEvUnexpectedState
+			}
+			src = ae.sm.stateTab[curID.RegSerial].getAny()
+			if ae.hook != nil {
+				if err := ae.hook(ctx, ae.sm.owner, src, ae.b.Get()); err != nil {
+					return STIDInvalid(), err
+				}
+			}
+			return ae.b.ID(), nil
+		},
+		ctx: ctx,
+		action: func(id StateID) {
+			if ae.action != nil {
+				ae.action(ae.sm.owner, src, ae.b.Get(), id)
+			}
+		},
+	})
+}
+
+// replay implement eventAgent
+func (ae *anyEventBind[O, B]) replay(codec Codec, data []byte) error {
+	return ae.Trigger()
+}
+
+// info implement eventAgent. source of the event is invalid state
+func (ae *anyEventBind[O, B]) info() EventInfo {
+	return EventInfo{
+		ID:   ae.id,
+		Name: ae.name,
+		From: STIDInvalid(),
+		To:   ae.b.ID(),
+		Kind: EvExternal,
+	}
+}
+
+// edges implement eventAgent. an edge is listed for each source state. caller
+// must hold the lock of state machine
+func (ae *anyEventBind[O, B]) edges() []EventInfo {
+	ret := []EventInfo{}
+	for i := range ae.sm.stateTab {
+		from := StateID{SMSerial: ae.sm.smSerial, RegSerial: i}
+		if from == ae.b.ID() || ae.except[i] {
+			continue
+		}
+		info := ae.info()
+		info.From = from
+		ret = append(ret, info)
+	}
+	return ret
+}
+
+// joinGroup implement eventAgent
+func (ae *anyEventBind[O, B]) joinGroup(members []EventID) {
+	ae.sm.addGroup(members)
+}
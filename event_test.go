@@ -0,0 +1,404 @@
+package genesm
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// reason is an example payload of event
+type reason struct {
+	code int
+	msg  string
+}
+
+func TestPayloadEvent(t *testing.T) {
+	Convey("Payload event test", t, func() {
+		sm := NewStateMachine("owner")
+		bndA := RegState(sm, 1)
+		bndB := RegState(sm, "B")
+		ctr := NewObsSyncController(0)
+		got := []any{}
+		bndA.AddObserver(CreateEventObserver(ctr, ObsEventPayloadFuncs(
+			nil, func(owner string, id StateID, val int, payload any) {
+				got = append(got, payload)
+			}, nil, nil,
+		), nil))
+		bndB.AddObserver(CreateEventObserver(ctr, ObsEventPayloadFuncs(
+			func(owner string, id StateID, val string, payload any) {
+				got = append(got, payload)
+			}, func(owner string, id StateID, val string, payload any) {
+				got = append(got, payload)
+			}, nil, nil,
+		), nil))
+
+		eA2B := RegPayloadEvent[reason](sm, bndA, bndB)
+		eB2A := RegEvent(sm, bndB, bndA)
+		errDeny := errors.New("deny")
+		eA2B.SetHook(func(owner string, a int, b string, p reason) error {
+			if p.code != 0 {
+				return errDeny
+			}
+			return nil
+		})
+		So(eA2B.Trigger(reason{code: 1}), ShouldEqual, errDeny)
+		So(got, ShouldBeEmpty)
+		So(eA2B.Trigger(reason{msg: "go"}), ShouldBeNil)
+		So(sm.StateID(), ShouldEqual, bndB.ID())
+		So(got, ShouldResemble, []any{reason{msg: "go"}, reason{msg: "go"}})
+		So(eA2B.Trigger(reason{}), ShouldEqual, ErrEvAlreadyChanged)
+		So(eB2A.Trigger(), ShouldBeNil)
+		So(got, ShouldResemble, []any{
+			reason{msg: "go"}, reason{msg: "go"}, nil})
+	})
+}
+
+func TestTriggerCtx(t *testing.T) {
+	type ctxKey struct{}
+	Convey("Trigger with context test", t, func() {
+		sm := NewStateMachine("owner")
+		bndA := RegState(sm, 1)
+		bndB := RegState(sm, 2)
+		eA2B := RegEvent(sm, bndA, bndB)
+		eB2A := RegEvent(sm, bndB, bndA)
+
+		// hook receive context
+		eA2B.SetHookCtx(func(ctx context.Context, o string, a, b int) error {
+			if ctx.Value(ctxKey{}) == nil {
+				return errors.New("no value")
+			}
+			return nil
+		})
+		So(eA2B.Trigger(), ShouldNotBeNil)
+		ctx := context.WithValue(context.Background(), ctxKey{}, true)
+		So(eA2B.TriggerCtx(ctx), ShouldBeNil)
+		So(sm.StateID(), ShouldEqual, bndB.ID())
+
+		// give up on state machine is locked
+		locked := make(chan struct{})
+		release := make(chan struct{})
+		go bndA.Protect(func(owner string, v int, selected bool) {
+			close(locked)
+			<-release
+		})
+		<-locked
+		tctx, cancel := context.WithTimeout(
+			context.Background(), 50*time.Millisecond)
+		defer cancel()
+		So(eB2A.TriggerCtx(tctx), ShouldEqual, context.DeadlineExceeded)
+		So(GroupEvent(eB2A).TriggerCtx(tctx), ShouldEqual,
+			context.DeadlineExceeded)
+		close(release)
+		So(sm.StateID(), ShouldEqual, bndB.ID())
+		So(eB2A.TriggerCtx(context.Background()), ShouldBeNil)
+
+		// give up on observer queue is full
+		ctr := NewObsController(ObsControlCfg{SizeEventQueue: 1})
+		block := make(chan struct{})
+		bndB.AddObserver(CreateEventObserver(ctr, ObsEventFuncs(
+			func(owner string, id StateID, val int) { <-block },
+			nil, nil, nil,
+		), nil))
+		eA2B.SetHook(nil)
+		So(eA2B.Trigger(), ShouldBeNil) // blocked in handler
+		So(eB2A.Trigger(), ShouldBeNil) // waiting for previous handler
+		So(eA2B.Trigger(), ShouldBeNil) // fill the queue
+		tctx2, cancel2 := context.WithTimeout(
+			context.Background(), 50*time.Millisecond)
+		defer cancel2()
+		So(eB2A.TriggerCtx(tctx2), ShouldEqual, context.DeadlineExceeded)
+		So(sm.StateID(), ShouldEqual, bndA.ID())
+		So((<-ctr.Warning()).Type, ShouldEqual, ObWEventDropped)
+		close(block)
+	})
+}
+
+func TestRunToCompletion(t *testing.T) {
+	// state transition:
+	//   -> A -> B -> C -> D
+	Convey("Run-to-completion test", t, func() {
+		sm := NewStateMachine("owner")
+		sm.SetRunToCompletion(true)
+		bndA := RegState(sm, "A")
+		bndB := RegState(sm, "B")
+		bndC := RegState(sm, "C")
+		bndD := RegState(sm, "D")
+		eA2B := RegEvent(sm, bndA, bndB)
+		eB2C := RegEvent(sm, bndB, bndC)
+		eC2D := RegEvent(sm, bndC, bndD)
+		eD2A := RegEvent(sm, bndD, bndA)
+
+		rec := []string{}
+		nested := []error{}
+		ctr := NewObsSyncController(0)
+		for _, bnd := range []StateBinder[string, string]{
+			bndA, bndB, bndC, bndD} {
+			bnd.AddObserver(orderRecorder[string](ctr, bnd.Get(), &rec))
+		}
+		// trigger in hook
+		eA2B.SetHook(func(owner, a, b string) error {
+			nested = append(nested, eB2C.Trigger())
+			return nil
+		})
+		// trigger in synchonous observer
+		bndC.AddObserver(CreateEventObserver(ctr, ObsEventFuncs(
+			func(owner string, id StateID, val string) {
+				nested = append(nested, eC2D.Trigger())
+				nested = append(nested, GroupEvent(eD2A).Trigger())
+			}, nil, nil, nil,
+		), nil))
+		So(eA2B.Trigger(), ShouldBeNil)
+		So(sm.StateID(), ShouldEqual, bndA.ID())
+		So(nested, ShouldResemble, []error{
+			ErrEvDeferred, ErrEvDeferred, ErrEvDeferred})
+		So(rec, ShouldResemble, []string{
+			"exit A", "enter B", "exit B", "enter C",
+			"exit C", "enter D", "exit D", "enter A",
+		})
+
+		// error of queued event is reported
+		nested = nested[:0]
+		eA2B.SetHook(func(owner, a, b string) error {
+			nested = append(nested, eC2D.Trigger())
+			return nil
+		})
+		So(eA2B.Trigger(), ShouldEqual, ErrEvUnexpectedState)
+		So(sm.StateID(), ShouldEqual, bndB.ID())
+		So(nested, ShouldResemble, []error{ErrEvDeferred})
+	})
+	Convey("Run-to-completion with concurrent triggers", t, func() {
+		sm := NewStateMachine("owner")
+		sm.SetRunToCompletion(true)
+		bndA, bndB := RegState(sm, "A"), RegState(sm, "B")
+		rg := NewRegion(sm)
+		bndX, bndY := RegState(rg, "X"), RegState(rg, "Y")
+		eA2B := RegEvent(sm, bndA, bndB)
+		eX2Y := RegEvent(rg, bndX, bndY)
+		eY2X := RegEvent(rg, bndY, bndX)
+		started, release := make(chan struct{}), make(chan struct{})
+		eA2B.SetHook(func(owner, a, b string) error {
+			close(started)
+			<-release
+			return nil
+		})
+		go eA2B.Trigger()
+		<-started
+
+		// other goroutines wait instead of being deferred
+		ctx, cancel := context.WithTimeout(
+			context.Background(), 20*time.Millisecond)
+		defer cancel()
+		So(eX2Y.TriggerCtx(ctx), ShouldEqual, context.DeadlineExceeded)
+		done := make(chan error)
+		go func() { done <- eX2Y.Trigger() }()
+		select {
+		case <-done:
+			So("not waiting", ShouldBeEmpty)
+		case <-time.After(20 * time.Millisecond):
+		}
+		close(release)
+		So(<-done, ShouldBeNil)
+		So(sm.StateIDs(), ShouldResemble, []StateID{bndB.ID(), bndY.ID()})
+		So(eY2X.Trigger(), ShouldBeNil)
+	})
+}
+
+func TestEventAction(t *testing.T) {
+	Convey("Post-transition action test", t, func() {
+		sm := NewStateMachine("owner")
+		bndA := RegState(sm, 1)
+		bndB := RegState(sm, "B")
+		eA2B := RegEvent(sm, bndA, bndB)
+		eB2A := RegPayloadEvent[int](sm, bndB, bndA)
+		rec := []string{}
+		count := map[string]int{}
+		ctr := NewObsSyncController(0)
+		bndB.AddObserver(orderRecorder[string](ctr, "B", &rec))
+		eA2B.SetHook(func(owner string, a int, b string) error {
+			rec = append(rec, "hook")
+			return nil
+		})
+		eA2B.SetAction(func(owner string, a int, b string, id StateID) {
+			rec = append(rec, "action")
+			So(a, ShouldEqual, 1)
+			So(b, ShouldEqual, "B")
+			So(id, ShouldEqual, bndB.ID())
+			So(bndB.IsSelected(), ShouldBeTrue)
+			count["A2B"]++
+		})
+		eB2A.SetAction(func(owner string, b string, a int, p int, id StateID) {
+			So(id, ShouldEqual, bndA.ID())
+			count["B2A"] += p
+		})
+		So(eA2B.Trigger(), ShouldBeNil)
+		So(rec, ShouldResemble, []string{"hook", "enter B", "action"})
+		So(eB2A.Trigger(5), ShouldBeNil)
+		So(eA2B.Trigger(), ShouldBeNil)
+		So(eA2B.Trigger(), ShouldEqual, ErrEvAlreadyChanged)
+		So(count, ShouldResemble, map[string]int{"A2B": 2, "B2A": 5})
+	})
+}
+
+func TestSelfAndInternalEvent(t *testing.T) {
+	Convey("Self-transition and internal transition test", t, func() {
+		sm := NewStateMachine("owner")
+		bndA := RegState(sm, "A")
+		bndLv := RegState(sm, "level")
+		sub := NewSubStateMachine(bndLv)
+		bndS1 := RegState(sub, "stage1")
+		bndS2 := RegState(sub, "stage2")
+		eStart := RegEvent(sm, bndA, bndLv)
+		eNext := RegEvent(sub, bndS1, bndS2)
+		eLoop := RegEvent(sm, bndLv, bndLv)
+		eRestart := RegSelfEvent(sm, bndLv)
+		eScore := RegInternalEvent(sm, bndLv)
+		So(func() { RegSelfEvent(sub, bndLv) }, ShouldPanic)
+
+		rec := []string{}
+		ctr := NewObsSyncController(0)
+		bndLv.AddObserver(orderRecorder[string](ctr, "level", &rec))
+		bndS1.AddObserver(orderRecorder[string](ctr, "stage1", &rec))
+		bndS2.AddObserver(orderRecorder[string](ctr, "stage2", &rec))
+		score := 0
+		eScore.SetAction(func(owner, a, b string, id StateID) {
+			score++
+		})
+		hooked := 0
+		eRestart.SetHook(func(owner, a, b string) error {
+			hooked++
+			return nil
+		})
+
+		So(eRestart.Trigger(), ShouldEqual, ErrEvUnexpectedState)
+		So(eScore.Trigger(), ShouldEqual, ErrEvUnexpectedState)
+		So(eStart.Trigger(), ShouldBeNil)
+		So(eNext.Trigger(), ShouldBeNil)
+		So(eLoop.Trigger(), ShouldEqual, ErrEvNothingTodo)
+		rec = rec[:0]
+		So(eScore.Trigger(), ShouldBeNil)
+		So(eScore.Trigger(), ShouldBeNil)
+		So(score, ShouldEqual, 2)
+		So(rec, ShouldBeEmpty)
+		So(eRestart.Trigger(), ShouldBeNil)
+		So(hooked, ShouldEqual, 1)
+		So(sm.StateIDs(), ShouldResemble, []StateID{bndLv.ID(), bndS1.ID()})
+		So(rec, ShouldResemble, []string{
+			"exit stage2", "exit level", "enter level", "enter stage1",
+		})
+	})
+}
+
+func TestTimedEvent(t *testing.T) {
+	// state transition:
+	//   -> idle <-> busy -(timeout)-> idle
+	Convey("Timed event test", t, func() {
+		sm := NewStateMachine("owner")
+		bndIdle := RegState(sm, "idle")
+		bndBusy := RegState(sm, "busy")
+		eWork := RegEvent(sm, bndIdle, bndBusy)
+		eDone := RegEvent(sm, bndBusy, bndIdle)
+		eTimeout := RegTimedEvent(sm, bndBusy, bndIdle, 50*time.Millisecond)
+		fired := make(chan StateID, 4)
+		eTimeout.SetAction(func(owner, a, b string, id StateID) {
+			fired <- id
+		})
+
+		// fire after dwell time
+		start := time.Now()
+		So(eWork.Trigger(), ShouldBeNil)
+		So(<-fired, ShouldEqual, bndIdle.ID())
+		So(time.Since(start), ShouldBeGreaterThanOrEqualTo,
+			50*time.Millisecond)
+		So(sm.StateID(), ShouldEqual, bndIdle.ID())
+
+		// cancelled on left earlier, and restarted on re-entering
+		So(eWork.Trigger(), ShouldBeNil)
+		time.Sleep(30 * time.Millisecond)
+		So(eDone.Trigger(), ShouldBeNil)
+		So(eWork.Trigger(), ShouldBeNil)
+		start = time.Now()
+		time.Sleep(30 * time.Millisecond)
+		So(sm.StateID(), ShouldEqual, bndBusy.ID())
+		So(<-fired, ShouldEqual, bndIdle.ID())
+		So(time.Since(start), ShouldBeGreaterThanOrEqualTo,
+			50*time.Millisecond)
+
+		// hook reject automatic trigger
+		eTimeout.SetHook(func(owner, a, b string) error {
+			return errors.New("keep busy")
+		})
+		So(eWork.Trigger(), ShouldBeNil)
+		time.Sleep(80 * time.Millisecond)
+		So(sm.StateID(), ShouldEqual, bndBusy.ID())
+		So(fired, ShouldBeEmpty)
+	})
+}
+
+func TestAnyEvent(t *testing.T) {
+	Convey("Event from any state", t, func() {
+		sm := NewStateMachine("owner")
+		bndIdle := RegState(sm, 0, "idle")
+		bndBusy := RegState(sm, "busy", "busy")
+		bndLocked := RegState(sm, 2.5, "locked")
+		bndAbort := RegState(sm, "aborted", "aborted")
+		sub := NewSubStateMachine(bndBusy)
+		RegState(sub, "job")
+		eWork := RegEvent(sm, bndIdle, bndBusy)
+		eLock := RegEvent(sm, bndBusy, bndLocked)
+		eAbort := RegAnyEvent(sm, bndAbort,
+			[]StateID{bndLocked.ID()}, "abort")
+		srcs := []any{}
+		acts := []any{}
+		eAbort.SetHook(func(owner string, cur any, b string) error {
+			srcs = append(srcs, cur)
+			if cur == 0 {
+				return errors.New("nothing to abort")
+			}
+			return nil
+		})
+		eAbort.SetAction(func(owner string, cur any, b string, id StateID) {
+			acts = append(acts, cur, sm.Describe(id))
+		})
+		So(LookupEvent[EventX[string, any, string]](sm, "abort"),
+			ShouldEqual, eAbort)
+
+		So(eAbort.Trigger(), ShouldNotBeNil)
+		So(eWork.Trigger(), ShouldBeNil)
+		avail := sm.AvailableEvents()
+		So(avail, ShouldHaveLength, 2)
+		So(avail[1].Name, ShouldEqual, "abort")
+		So(avail[1].From, ShouldEqual, bndBusy.ID())
+		So(eAbort.Trigger(), ShouldBeNil)
+		So(sm.StateID(), ShouldEqual, bndAbort.ID())
+		So(sub.IsActive(), ShouldBeFalse)
+		So(eAbort.Trigger(), ShouldEqual, ErrEvAlreadyChanged)
+		So(srcs, ShouldResemble, []any{0, "busy"})
+		So(acts, ShouldResemble, []any{"busy", "aborted"})
+
+		So(sm.Reset(), ShouldBeNil)
+		So(eWork.Trigger(), ShouldBeNil)
+		So(eLock.Trigger(), ShouldBeNil)
+		So(eAbort.Trigger(), ShouldEqual, ErrEvUnexpectedState)
+		So(sub.Close(), ShouldEqual, ErrNotRoot)
+
+		edges := 0
+		for _, ev := range sm.Events() {
+			if ev.ID == eAbort.ID() {
+				So(ev.From, ShouldBeIn, bndIdle.ID(), bndBusy.ID())
+				So(ev.To, ShouldEqual, bndAbort.ID())
+				edges++
+			}
+		}
+		So(edges, ShouldEqual, 2)
+		buf := &strings.Builder{}
+		So(sm.ExportDOT(buf, nil), ShouldBeNil)
+		So(strings.Count(buf.String(), `label="abort"`), ShouldEqual, 2)
+		So(func() {
+			RegAnyEvent(sm, bndAbort, []StateID{sub.InitialID()})
+		}, ShouldPanic)
+	})
+}
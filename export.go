@@ -0,0 +1,327 @@
+package genesm
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ExportOptions customize diagram exporting
+//
+// StateLabels and EventLabels give labels of states and events. a state
+// without label is shown by its name, or its value if it is not named. an
+// event without label is shown by its name, or its kind (self, internal or
+// dwell time of timed event), or nothing.
+type ExportOptions struct {
+	Title       string
+	StateLabels map[StateID]string
+	EventLabels map[EventID]string
+}
+
+// stateLabel get label of a state
+func (opt *ExportOptions) stateLabel(st *graphState) string {
+	if opt != nil {
+		if l, ok := opt.StateLabels[st.id]; ok {
+			return l
+		}
+	}
+	if st.name != "" {
+		return st.name
+	}
+	return fmt.Sprint(st.value)
+}
+
+// eventLabel get label of an event
+func (opt *ExportOptions) eventLabel(ev EventInfo) string {
+	if opt != nil {
+		if l, ok := opt.EventLabels[ev.ID]; ok {
+			return l
+		}
+	}
+	if ev.Name != "" {
+		return ev.Name
+	}
+	return eventNote(ev)
+}
+
+// edgeLabel get label of an event. a member of event group is labeled with
+// its order in the group. it also return index of the group, or -1 if the
+// event is not grouped
+func (g *graph) edgeLabel(ev EventInfo, opt *ExportOptions) (string, int) {
+	label := opt.eventLabel(ev)
+	idx, order := g.groupOf(ev.ID)
+	if idx >= 0 {
+		label = strings.TrimSpace(fmt.Sprintf("#%d %s", order+1, label))
+	}
+	return label, idx
+}
+
+// title get title of diagram
+func (opt *ExportOptions) title() string {
+	if opt == nil || opt.Title == "" {
+		return "genesm"
+	}
+	return opt.Title
+}
+
+// dotColors is palette of event groups in DOT
+var dotColors = []string{
+	"blue", "darkgreen", "orange", "purple", "brown", "deeppink",
+}
+
+// ExportDOT write state graph of the whole tree of state machine to w in
+// Graphviz DOT format. opt could be nil.
+//
+// a sub state machine is drawn as a cluster of its upper state, and a region
+// is drawn as a dashed cluster. selected states are highlighted. members of
+// an event group are drawn in the same color, labeled with their order in
+// the group.
+func (sm *StateMachine[O]) ExportDOT(w io.Writer, opt *ExportOptions) error {
+	g := sm.buildGraph()
+	b := &strings.Builder{}
+	fmt.Fprintf(b, "digraph %s {\n", dotQuote(opt.title()))
+	b.WriteString("\tcompound=true;\n")
+	b.WriteString("\tnode [shape=box, style=rounded];\n")
+	dotMachine(b, g.root, opt, "\t")
+	g.root.eachEvent(func(ev EventInfo) {
+		from, to := g.states[ev.From], g.states[ev.To]
+		attrs := []string{}
+		label, idx := g.edgeLabel(ev, opt)
+		if idx >= 0 {
+			attrs = append(attrs,
+				"color="+dotColors[idx%len(dotColors)],
+				"fontcolor="+dotColors[idx%len(dotColors)])
+		}
+		if label != "" {
+			attrs = append(attrs, "label="+dotQuote(label))
+		}
+		if ev.Kind == EvInternal {
+			attrs = append(attrs, "style=dashed")
+		}
+		if ev.From != ev.To {
+			if from.sub != nil {
+				attrs = append(attrs, "ltail="+dotCluster(from.id))
+			}
+			if to.sub != nil {
+				attrs = append(attrs, "lhead="+dotCluster(to.id))
+			}
+		}
+		fmt.Fprintf(b, "\t%s -> %s", dotNode(ev.From), dotNode(ev.To))
+		if len(attrs) != 0 {
+			fmt.Fprintf(b, " [%s]", strings.Join(attrs, ", "))
+		}
+		b.WriteString(";\n")
+	})
+	b.WriteString("}\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// dotMachine write states of a state machine recursively
+func dotMachine(
+	b *strings.Builder, gm *graphMachine, opt *ExportOptions, indent string,
+) {
+	if !gm.initial.IsInvalid() {
+		fmt.Fprintf(b, "%sinit_%d [shape=point];\n", indent, gm.serial)
+		fmt.Fprintf(b, "%sinit_%d -> %s", indent, gm.serial, dotNode(gm.initial))
+		if gm.states[gm.initial.RegSerial].sub != nil {
+			fmt.Fprintf(b, " [lhead=%s]", dotCluster(gm.initial))
+		}
+		b.WriteString(";\n")
+	}
+	for i := range gm.states {
+		st := &gm.states[i]
+		label := dotQuote(opt.stateLabel(st))
+		if st.sub == nil {
+			attrs := "label=" + label
+			if st.final {
+				attrs += ", peripheries=2"
+			}
+			if st.selected {
+				attrs += ", color=red, penwidth=2"
+			}
+			fmt.Fprintf(b, "%s%s [%s];\n", indent, dotNode(st.id), attrs)
+			continue
+		}
+		fmt.Fprintf(b, "%ssubgraph %s {\n", indent, dotCluster(st.id))
+		fmt.Fprintf(b, "%s\tlabel=%s;\n", indent, label)
+		fmt.Fprintf(b, "%s\tstyle=rounded;\n", indent)
+		if st.selected {
+			fmt.Fprintf(b, "%s\tcolor=red;\n%s\tpenwidth=2;\n", indent, indent)
+		}
+		fmt.Fprintf(b, "%s\t%s [shape=point, style=invis];\n",
+			indent, dotNode(st.id))
+		dotMachine(b, st.sub, opt, indent+"\t")
+		fmt.Fprintf(b, "%s}\n", indent)
+	}
+	for _, rg := range gm.regions {
+		fmt.Fprintf(b, "%ssubgraph cluster_r%d {\n", indent, rg.serial)
+		fmt.Fprintf(b, "%s\tlabel=\"\";\n%s\tstyle=dashed;\n", indent, indent)
+		dotMachine(b, rg, opt, indent+"\t")
+		fmt.Fprintf(b, "%s}\n", indent)
+	}
+}
+
+// dotNode get node ID of a state in DOT
+func dotNode(id StateID) string {
+	return fmt.Sprintf("s%d_%d", id.SMSerial, id.RegSerial)
+}
+
+// dotCluster get cluster ID of a composite state in DOT
+func dotCluster(id StateID) string {
+	return fmt.Sprintf("cluster_s%d_%d", id.SMSerial, id.RegSerial)
+}
+
+// dotQuote quote a string as DOT ID
+func dotQuote(s string) string {
+	s = strings.ReplaceAll(s, "\\", "\\\\")
+	s = strings.ReplaceAll(s, "\"", "\\\"")
+	s = strings.ReplaceAll(s, "\n", "\\n")
+	return "\"" + s + "\""
+}
+
+// ExportMermaid write state graph of the whole tree of state machine to w as
+// a Mermaid stateDiagram-v2. opt could be nil.
+//
+// a sub state machine is drawn as a composite state, and regions are
+// separated by "--". regions of root state machine are drawn as composite
+// states. selected states are highlighted by class "current". members of an
+// event group are labeled with group and their order in the group.
+func (sm *StateMachine[O]) ExportMermaid(
+	w io.Writer, opt *ExportOptions,
+) error {
+	g := sm.buildGraph()
+	b := &strings.Builder{}
+	fmt.Fprintf(b, "---\ntitle: %s\n---\n", mmdText(opt.title()))
+	b.WriteString("stateDiagram-v2\n")
+	selected := []string{}
+	umlMachine(b, g.root, "\t", true, func(st *graphState, indent string) {
+		fmt.Fprintf(b, "%sstate \"%s\" as %s\n",
+			indent, mmdText(opt.stateLabel(st)), dotNode(st.id))
+		if st.sub != nil {
+			fmt.Fprintf(b, "%sstate %s {\n", indent, dotNode(st.id))
+		}
+		if st.selected {
+			selected = append(selected, dotNode(st.id))
+		}
+	}, func(rg *graphMachine, n int, indent string) {
+		fmt.Fprintf(b, "%sstate \"region %d\" as r%d {\n", indent, n, rg.serial)
+	})
+	g.root.eachEvent(func(ev EventInfo) {
+		label := opt.eventLabel(ev)
+		if idx, order := g.groupOf(ev.ID); idx >= 0 {
+			label = strings.TrimSpace(
+				fmt.Sprintf("g%d.%d %s", idx+1, order+1, label))
+		}
+		fmt.Fprintf(b, "\t%s --> %s", dotNode(ev.From), dotNode(ev.To))
+		if label != "" {
+			fmt.Fprintf(b, " : %s", mmdText(label))
+		}
+		b.WriteString("\n")
+	})
+	if len(selected) != 0 {
+		b.WriteString("\tclassDef current stroke:red,stroke-width:3px\n")
+		fmt.Fprintf(b, "\tclass %s current\n", strings.Join(selected, ","))
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// ExportPlantUML write state graph of the whole tree of state machine to w as
+// a PlantUML state diagram. opt could be nil.
+//
+// a sub state machine is drawn as a composite state, and regions are
+// separated by "--". regions of root state machine are drawn as composite
+// states. selected states are highlighted. members of an event group are
+// drawn in the same color, labeled with their order in the group.
+func (sm *StateMachine[O]) ExportPlantUML(
+	w io.Writer, opt *ExportOptions,
+) error {
+	g := sm.buildGraph()
+	b := &strings.Builder{}
+	b.WriteString("@startuml\n")
+	fmt.Fprintf(b, "title %s\n", umlText(opt.title()))
+	umlMachine(b, g.root, "", true, func(st *graphState, indent string) {
+		fmt.Fprintf(b, "%sstate \"%s\" as %s",
+			indent, umlText(opt.stateLabel(st)), dotNode(st.id))
+		if st.selected {
+			b.WriteString(" ##[bold]red")
+		}
+		if st.sub != nil {
+			b.WriteString(" {")
+		}
+		b.WriteString("\n")
+	}, func(rg *graphMachine, n int, indent string) {
+		fmt.Fprintf(b, "%sstate \"region %d\" as r%d {\n", indent, n, rg.serial)
+	})
+	g.root.eachEvent(func(ev EventInfo) {
+		label, idx := g.edgeLabel(ev, opt)
+		arrow := "-->"
+		if idx >= 0 {
+			arrow = "-[#" + dotColors[idx%len(dotColors)] + "]->"
+		}
+		fmt.Fprintf(b, "%s %s %s", dotNode(ev.From), arrow, dotNode(ev.To))
+		if label != "" {
+			fmt.Fprintf(b, " : %s", umlText(label))
+		}
+		b.WriteString("\n")
+	})
+	b.WriteString("@enduml\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// umlMachine write states of a state machine recursively in common syntax
+// of Mermaid and PlantUML. state write declaration of a state, and open the
+// composite state if it have sub state machine. region open a composite state
+// for a region of root state machine
+func umlMachine(
+	b *strings.Builder, gm *graphMachine, indent string, root bool,
+	state func(st *graphState, indent string),
+	region func(rg *graphMachine, n int, indent string),
+) {
+	umlStates(b, gm, indent, state)
+	for i, rg := range gm.regions {
+		if root {
+			region(rg, i+1, indent)
+			umlMachine(b, rg, indent+"\t", false, state, region)
+			fmt.Fprintf(b, "%s}\n", indent)
+			continue
+		}
+		fmt.Fprintf(b, "%s--\n", indent)
+		umlMachine(b, rg, indent, false, state, region)
+	}
+}
+
+// umlStates write initial, states and final states of a state machine
+func umlStates(
+	b *strings.Builder, gm *graphMachine, indent string,
+	state func(st *graphState, indent string),
+) {
+	if !gm.initial.IsInvalid() {
+		fmt.Fprintf(b, "%s[*] --> %s\n", indent, dotNode(gm.initial))
+	}
+	for i := range gm.states {
+		st := &gm.states[i]
+		state(st, indent)
+		if st.sub != nil {
+			umlMachine(b, st.sub, indent+"\t", false, state, nil)
+			fmt.Fprintf(b, "%s}\n", indent)
+		}
+		if st.final {
+			fmt.Fprintf(b, "%s%s --> [*]\n", indent, dotNode(st.id))
+		}
+	}
+}
+
+// mmdText escape text for Mermaid
+func mmdText(s string) string {
+	s = strings.ReplaceAll(s, "\"", "#quot;")
+	return strings.ReplaceAll(s, "\n", " ")
+}
+
+// umlText escape text for PlantUML
+func umlText(s string) string {
+	s = strings.ReplaceAll(s, "\"", "'")
+	return strings.ReplaceAll(s, "\n", "\\n")
+}
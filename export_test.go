@@ -0,0 +1,131 @@
+package genesm
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestExportDOT(t *testing.T) {
+	// state transition:
+	//   -> idle -> run{ fast <-> slow } -> [stop]
+	//              run -(after 1s)-> idle
+	Convey("DOT export test", t, func() {
+		sm := NewStateMachine("owner")
+		bndIdle := RegState(sm, "idle")
+		bndRun := RegState(sm, "run")
+		bndStop := RegState(sm, "stop")
+		run := NewSubStateMachine(bndRun)
+		bndFast := RegState(run, "fast")
+		bndSlow := RegState(run, "slow")
+		sm.SetFinal(bndStop.ID())
+		eStart := RegEvent(sm, bndIdle, bndRun)
+		eStop := RegEvent(sm, bndRun, bndStop)
+		eCancel := RegEvent(sm, bndIdle, bndStop)
+		eTimeout := RegTimedEvent(sm, bndRun, bndIdle, time.Second)
+		eSlow := RegEvent(run, bndFast, bndSlow)
+		RegEvent(run, bndSlow, bndFast)
+		GroupEvent(eStart, eCancel)
+		GroupEvent(eStart, eCancel) // same group is kept once
+		So(eStart.Trigger(), ShouldBeNil)
+		So(eSlow.Trigger(), ShouldBeNil)
+
+		buf := &strings.Builder{}
+		So(sm.ExportDOT(buf, &ExportOptions{
+			Title:       "job",
+			StateLabels: map[StateID]string{bndSlow.ID(): "slow \"mode\""},
+			EventLabels: map[EventID]string{eStop.ID(): "stop"},
+		}), ShouldBeNil)
+		out := buf.String()
+		node := func(sb interface{ ID() StateID }) string {
+			return fmt.Sprintf("s%d_%d", sb.ID().SMSerial, sb.ID().RegSerial)
+		}
+		So(out, ShouldStartWith, "digraph \"job\" {\n")
+		So(out, ShouldContainSubstring, node(bndIdle)+" [label=\"idle\"];")
+		So(out, ShouldContainSubstring,
+			node(bndStop)+" [label=\"stop\", peripheries=2];")
+		So(out, ShouldContainSubstring, "subgraph cluster_"+node(bndRun)+" {")
+		So(out, ShouldContainSubstring, node(bndSlow)+
+			" [label=\"slow \\\"mode\\\"\", color=red, penwidth=2];")
+		So(out, ShouldContainSubstring, node(bndRun)+" -> "+node(bndStop)+
+			" [label=\"stop\", ltail=cluster_"+node(bndRun)+"];")
+		So(out, ShouldContainSubstring, node(bndRun)+" -> "+node(bndIdle)+
+			" [label=\"after 1s\", ltail=cluster_"+node(bndRun)+"];")
+		So(out, ShouldContainSubstring, node(bndIdle)+" -> "+node(bndRun)+
+			" [color=blue, fontcolor=blue, label=\"#1\", lhead=cluster_"+
+			node(bndRun)+"];")
+		So(out, ShouldContainSubstring, node(bndIdle)+" -> "+node(bndStop)+
+			" [color=blue, fontcolor=blue, label=\"#2\"];")
+		So(strings.Count(out, "[color=blue"), ShouldEqual, 2)
+		So(eTimeout.ID().IsInvalid(), ShouldBeFalse)
+	})
+}
+
+func TestExportUML(t *testing.T) {
+	// state transition:
+	//   -> off <-> on{ low <-> high }
+	//   region: -> unlocked -> [locked]
+	Convey("Mermaid and PlantUML export test", t, func() {
+		sm := NewStateMachine("owner")
+		bndOff := RegState(sm, "off")
+		bndOn := RegState(sm, "on")
+		on := NewSubStateMachine(bndOn)
+		bndLow := RegState(on, "low")
+		bndHigh := RegState(on, "high")
+		lock := NewRegion(sm)
+		bndUnlocked := RegState(lock, "unlocked")
+		bndLocked := RegState(lock, "locked")
+		lock.SetFinal(bndLocked.ID())
+		eOn := RegEvent(sm, bndOff, bndOn)
+		eOff := RegEvent(sm, bndOn, bndOff)
+		eUp := RegEvent(on, bndLow, bndHigh)
+		eDown := RegEvent(on, bndHigh, bndLow)
+		RegEvent(lock, bndUnlocked, bndLocked)
+		GroupEvent(eUp, eDown)
+		So(eOn.Trigger(), ShouldBeNil)
+		id := func(sb interface{ ID() StateID }) string {
+			return fmt.Sprintf("s%d_%d", sb.ID().SMSerial, sb.ID().RegSerial)
+		}
+		opt := &ExportOptions{EventLabels: map[EventID]string{
+			eOff.ID(): "power \"off\"",
+			eUp.ID():  "up",
+		}}
+
+		buf := &strings.Builder{}
+		So(sm.ExportMermaid(buf, opt), ShouldBeNil)
+		out := buf.String()
+		So(out, ShouldStartWith, "---\ntitle: genesm\n---\nstateDiagram-v2\n")
+		So(out, ShouldContainSubstring, "\t[*] --> "+id(bndOff)+"\n")
+		So(out, ShouldContainSubstring, "\tstate \"on\" as "+id(bndOn)+"\n"+
+			"\tstate "+id(bndOn)+" {\n\t\t[*] --> "+id(bndLow)+"\n")
+		So(out, ShouldContainSubstring, fmt.Sprintf(
+			"\tstate \"region 1\" as r%d {\n", lock.Serial()))
+		So(out, ShouldContainSubstring, "\t\t"+id(bndLocked)+" --> [*]\n")
+		So(out, ShouldContainSubstring,
+			id(bndOn)+" --> "+id(bndOff)+" : power #quot;off#quot;\n")
+		So(out, ShouldContainSubstring,
+			id(bndLow)+" --> "+id(bndHigh)+" : g1.1 up\n")
+		So(out, ShouldContainSubstring,
+			id(bndHigh)+" --> "+id(bndLow)+" : g1.2\n")
+		So(out, ShouldContainSubstring, "\tclass "+id(bndOn)+","+id(bndLow)+
+			","+id(bndUnlocked)+" current\n")
+
+		buf.Reset()
+		So(sm.ExportPlantUML(buf, opt), ShouldBeNil)
+		out = buf.String()
+		So(out, ShouldStartWith, "@startuml\ntitle genesm\n")
+		So(out, ShouldEndWith, "@enduml\n")
+		So(out, ShouldContainSubstring,
+			"state \"on\" as "+id(bndOn)+" ##[bold]red {\n")
+		So(out, ShouldContainSubstring, "\tstate \"high\" as "+id(bndHigh)+"\n")
+		So(out, ShouldContainSubstring, fmt.Sprintf(
+			"state \"region 1\" as r%d {\n", lock.Serial()))
+		So(out, ShouldContainSubstring,
+			id(bndOn)+" --> "+id(bndOff)+" : power 'off'\n")
+		So(out, ShouldContainSubstring,
+			id(bndLow)+" -[#blue]-> "+id(bndHigh)+" : #1 up\n")
+	})
+}
@@ -0,0 +1,241 @@
+package genesm
+
+// graphMachine is a snapshot of state graph of a state machine
+type graphMachine struct {
+	serial  uint32
+	active  bool
+	initial StateID
+	states  []graphState
+	events  []EventInfo
+	regions []*graphMachine
+}
+
+// graphState is a state in state graph
+type graphState struct {
+	id       StateID
+	name     string
+	value    any
+	selected bool
+	final    bool
+	sub      *graphMachine // graph of sub state machine
+}
+
+// graph is a snapshot of state graph of whole tree of state machine
+type graph struct {
+	root   *graphMachine
+	states map[StateID]*graphState
+	groups [][]EventID // groups which have member in the tree
+}
+
+// addGroup remember an event group. a group with same members is only kept
+// once. it never lock the state machine, so it is safe to group events in
+// hooks and observers
+func (sm *StateMachine[O]) addGroup(members []EventID) {
+	sm.grpMux.Lock()
+	defer sm.grpMux.Unlock()
+	for _, g := range sm.groups {
+		if sameEvents(g, members) {
+			return
+		}
+	}
+	sm.groups = append(sm.groups, members)
+}
+
+// sameEvents check whether two lists of EventID are equal
+func sameEvents(a, b []EventID) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// buildGraph walk the whole tree of state machine and build state graph
+func (sm *StateMachine[O]) buildGraph() *graph {
+	g := &graph{states: map[StateID]*graphState{}}
+	sm.mux.RLock()
+	defer sm.mux.RUnlock()
+	g.root = sm.graphOn(g)
+	return g
+}
+
+// graphOn build state graph recursively. caller must hold the lock
+func (sm *StateMachine[O]) graphOn(g *graph) *graphMachine {
+	gm := &graphMachine{
+		serial:  sm.smSerial,
+		active:  sm.active,
+		initial: STIDInvalid(),
+		states:  make([]graphState, len(sm.stateTab)),
+		events:  make([]EventInfo, 0, len(sm.evTab)),
+	}
+	if len(sm.stateTab) != 0 {
+		gm.initial = sm.initialID()
+	}
+	for i, st := range sm.stateTab {
+		gs := &gm.states[i]
+		gs.id = StateID{SMSerial: sm.smSerial, RegSerial: i}
+		gs.name = sm.StateName(gs.id)
+		gs.value = st.getAny()
+		gs.selected = sm.active && sm.stateOn == gs.id
+		gs.final = sm.finals[i]
+		if sub := st.subMachine(); sub != nil {
+			sub.mux.RLock()
+			gs.sub = sub.graphOn(g)
+			sub.mux.RUnlock()
+		}
+		g.states[gs.id] = gs
+	}
+	for _, ev := range sm.evTab {
+		gm.events = append(gm.events, ev.edges()...)
+	}
+	sm.grpMux.Lock()
+	for _, grp := range sm.groups {
+		found := false
+		for _, exist := range g.groups {
+			if sameEvents(exist, grp) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			g.groups = append(g.groups, grp)
+		}
+	}
+	sm.grpMux.Unlock()
+	for _, rg := range sm.regions {
+		rg.mux.RLock()
+		gm.regions = append(gm.regions, rg.graphOn(g))
+		rg.mux.RUnlock()
+	}
+	return gm
+}
+
+// eachEvent call f with each event of the graph in registing order. events
+// of upper state machine are ahead of its sub state machines and regions
+func (gm *graphMachine) eachEvent(f func(ev EventInfo)) {
+	for _, ev := range gm.events {
+		f(ev)
+	}
+	for _, st := range gm.states {
+		if st.sub != nil {
+			st.sub.eachEvent(f)
+		}
+	}
+	for _, rg := range gm.regions {
+		rg.eachEvent(f)
+	}
+}
+
+// groupOf find the group which contain an event. it return index of the
+// group and order of the event in the group. index is -1 if the event is not
+// a member of any group. an event belongs to the first group it joined
+func (g *graph) groupOf(id EventID) (idx int, order int) {
+	for i, grp := range g.groups {
+		for j, m := range grp {
+			if m == id {
+				return i, j
+			}
+		}
+	}
+	return -1, 0
+}
+
+// eventNote get default note of an event by its kind
+func eventNote(ev EventInfo) string {
+	switch {
+	case ev.Dwell > 0:
+		return "after " + ev.Dwell.String()
+	case ev.Kind == EvInternal:
+		return "internal"
+	case ev.Kind == EvSelf:
+		return "self"
+	}
+	return ""
+}
+
+// StateInfo describe a registed state
+type StateInfo struct {
+	ID        StateID
+	Name      string
+	Value     any
+	Initial   bool
+	Final     bool
+	Selected  bool
+	Composite bool // whether the state have a sub state machine
+}
+
+// States list all states registed on the state machine in registing order.
+// states of sub state machines and regions are not included
+func (sm *StateMachine[O]) States() []StateInfo {
+	sm.mux.RLock()
+	defer sm.mux.RUnlock()
+	ret := make([]StateInfo, len(sm.stateTab))
+	for i, st := range sm.stateTab {
+		id := StateID{SMSerial: sm.smSerial, RegSerial: i}
+		ret[i] = StateInfo{
+			ID:        id,
+			Name:      sm.StateName(id),
+			Value:     st.getAny(),
+			Initial:   i == sm.initial,
+			Final:     sm.finals[i],
+			Selected:  sm.active && sm.stateOn == id,
+			Composite: st.subMachine() != nil,
+		}
+	}
+	return ret
+}
+
+// Events list all events registed on the state machine in registing order.
+// events of sub state machines and regions are not included. an event from
+// any state (see RegAnyEvent) is listed once for each source state
+func (sm *StateMachine[O]) Events() []EventInfo {
+	sm.mux.RLock()
+	defer sm.mux.RUnlock()
+	ret := make([]EventInfo, 0, len(sm.evTab))
+	for _, ev := range sm.evTab {
+		ret = append(ret, ev.edges()...)
+	}
+	return ret
+}
+
+// AvailableEvents list events which source is a selected state. events of
+// active sub state machines and regions are included, upper ones are ahead.
+// events of finished state machines are excluded.
+//
+// an available event could still be rejected by its hook.
+func (sm *StateMachine[O]) AvailableEvents() []EventInfo {
+	sm.mux.RLock()
+	defer sm.mux.RUnlock()
+	return sm.availableOn(nil)
+}
+
+// availableOn append available events recursively. caller must hold the lock
+func (sm *StateMachine[O]) availableOn(evs []EventInfo) []EventInfo {
+	if !sm.active || sm.finished {
+		return evs
+	}
+	if len(sm.stateTab) != 0 {
+		for _, ev := range sm.evTab {
+			for _, info := range ev.edges() {
+				if info.From == sm.stateOn {
+					evs = append(evs, info)
+				}
+			}
+		}
+		if sub := sm.stateTab[sm.stateOn.RegSerial].subMachine(); sub != nil {
+			sub.mux.RLock()
+			evs = sub.availableOn(evs)
+			sub.mux.RUnlock()
+		}
+	}
+	for _, rg := range sm.regions {
+		rg.mux.RLock()
+		evs = rg.availableOn(evs)
+		rg.mux.RUnlock()
+	}
+	return evs
+}
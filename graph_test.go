@@ -0,0 +1,65 @@
+package genesm
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestIntrospection(t *testing.T) {
+	// state transition:
+	//   -> menu <-> game{ play -> pause } -> [over]
+	//   region: -> quiet <-> loud
+	Convey("Introspection test", t, func() {
+		sm := NewStateMachine("owner")
+		bndMenu := RegState(sm, 0, "menu")
+		bndGame := RegState(sm, 1, "game")
+		bndOver := RegState(sm, 2, "over")
+		game := NewSubStateMachine(bndGame)
+		bndPlay := RegState(game, "play")
+		bndPause := RegState(game, "pause")
+		sound := NewRegion(sm)
+		bndQuiet := RegState(sound, true)
+		bndLoud := RegState(sound, false)
+		sm.SetFinal(bndOver.ID())
+		eStart := RegEvent(sm, bndMenu, bndGame, "start")
+		eQuit := RegEvent(sm, bndGame, bndMenu)
+		eOver := RegTimedEvent(sm, bndGame, bndOver, time.Hour)
+		ePause := RegEvent(game, bndPlay, bndPause)
+		eLoud := RegEvent(sound, bndQuiet, bndLoud)
+		RegEvent(sound, bndLoud, bndQuiet)
+		eTick := RegInternalEvent(sound, bndQuiet)
+
+		states := sm.States()
+		So(len(states), ShouldEqual, 3)
+		So(states[0], ShouldResemble, StateInfo{
+			ID: bndMenu.ID(), Name: "menu", Value: 0,
+			Initial: true, Selected: true,
+		})
+		So(states[1].Composite, ShouldBeTrue)
+		So(states[2].Final, ShouldBeTrue)
+		evs := sm.Events()
+		So(len(evs), ShouldEqual, 3)
+		So(evs[0], ShouldResemble, EventInfo{
+			ID: eStart.ID(), Name: "start",
+			From: bndMenu.ID(), To: bndGame.ID(), Kind: EvExternal,
+		})
+		So(evs[2].Dwell, ShouldEqual, time.Hour)
+		So(sound.Events()[2].Kind, ShouldEqual, EvInternal)
+
+		ids := func(evs []EventInfo) []EventID {
+			ret := []EventID{}
+			for _, ev := range evs {
+				ret = append(ret, ev.ID)
+			}
+			return ret
+		}
+		So(ids(sm.AvailableEvents()), ShouldResemble, []EventID{
+			eStart.ID(), eLoud.ID(), eTick.ID()})
+		So(eStart.Trigger(), ShouldBeNil)
+		So(ids(sm.AvailableEvents()), ShouldResemble, []EventID{
+			eQuit.ID(), eOver.ID(), ePause.ID(), eLoud.ID(), eTick.ID()})
+		So(ids(game.AvailableEvents()), ShouldResemble, []EventID{ePause.ID()})
+	})
+}
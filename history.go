@@ -0,0 +1,71 @@
+package genesm
+
+import "time"
+
+// TransRecord is a record of transition history. both succeed and rejected
+// transitions are recorded.
+//
+// From is the state selected before the transition. To is the state selected
+// after a succeed transition, or the expected state of a rejected one. Event
+// is invalid for a Reset. Err is the result which returned to the trigger, it
+// may be an error of hook, ErrEvUnexpectedState and so on.
+type TransRecord struct {
+	Time  time.Time
+	From  StateID
+	To    StateID
+	Event EventID
+	Err   error
+}
+
+// EnableHistory enable transition history with a bounded size. once the
+// history is full, the oldest record is dropped. previous records are
+// discarded on each call. a non-positive size disable the history.
+//
+// each StateMachine keep own history. only events registed on it and Reset
+// are recorded.
+func (sm *StateMachine[O]) EnableHistory(size int) {
+	sm.mux.Lock()
+	defer sm.mux.Unlock()
+	if size < 0 {
+		size = 0
+	}
+	sm.hisSize = size
+	sm.hisBuf = nil
+	sm.hisNext = 0
+}
+
+// History get a snapshot of transition history. records are ordered from
+// oldest to newest
+func (sm *StateMachine[O]) History() []TransRecord {
+	sm.mux.RLock()
+	defer sm.mux.RUnlock()
+	ret := make([]TransRecord, 0, len(sm.hisBuf))
+	if len(sm.hisBuf) == sm.hisSize {
+		ret = append(ret, sm.hisBuf[sm.hisNext:]...)
+		return append(ret, sm.hisBuf[:sm.hisNext]...)
+	}
+	return append(ret, sm.hisBuf...)
+}
+
+// record append result of a transition to history. caller must hold the lock
+func (sm *StateMachine[O]) record(tr transition, from StateID, err error) {
+	if sm.hisSize == 0 || err == errEvStale {
+		return
+	}
+	rec := TransRecord{
+		Time:  time.Now(),
+		From:  from,
+		To:    tr.target,
+		Event: tr.event,
+		Err:   err,
+	}
+	if cur := sm.selectedID(); err == nil || cur != from {
+		rec.To = cur
+	}
+	if len(sm.hisBuf) < sm.hisSize {
+		sm.hisBuf = append(sm.hisBuf, rec)
+	} else {
+		sm.hisBuf[sm.hisNext] = rec
+	}
+	sm.hisNext = (sm.hisNext + 1) % sm.hisSize
+}
@@ -0,0 +1,58 @@
+package genesm
+
+import (
+	"errors"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestTransHistory(t *testing.T) {
+	Convey("Transition history test", t, func() {
+		sm := NewStateMachine("owner")
+		bndA := RegState(sm, "A")
+		bndB := RegState(sm, "B")
+		eA2B := RegEvent(sm, bndA, bndB)
+		eB2A := RegEvent(sm, bndB, bndA)
+		So(eA2B.ID(), ShouldResemble, EventID{SMSerial: sm.Serial()})
+		So(eB2A.ID().RegSerial, ShouldEqual, 1)
+		So(EvIDInvalid().IsInvalid(), ShouldBeTrue)
+
+		So(eA2B.Trigger(), ShouldBeNil)
+		So(sm.History(), ShouldBeEmpty)
+		sm.EnableHistory(3)
+		errDeny := errors.New("deny")
+		eB2A.SetHook(func(owner, a, b string) error { return errDeny })
+		So(eB2A.Trigger(), ShouldEqual, errDeny)
+		So(eA2B.Trigger(), ShouldEqual, ErrEvAlreadyChanged)
+		eB2A.SetHook(nil)
+		So(eB2A.Trigger(), ShouldBeNil)
+
+		his := sm.History()
+		So(len(his), ShouldEqual, 3)
+		So(his[0].Event, ShouldEqual, eB2A.ID())
+		So(his[0].From, ShouldEqual, bndB.ID())
+		So(his[0].To, ShouldEqual, bndA.ID())
+		So(his[0].Err, ShouldEqual, errDeny)
+		So(his[1].Err, ShouldEqual, ErrEvAlreadyChanged)
+		So(his[2].Err, ShouldBeNil)
+		So(his[2].To, ShouldEqual, bndA.ID())
+		So(his[2].Time, ShouldHappenOnOrAfter, his[0].Time)
+
+		// oldest record is dropped
+		So(eA2B.Trigger(), ShouldBeNil)
+		So(sm.Reset(), ShouldBeNil)
+		his = sm.History()
+		So(len(his), ShouldEqual, 3)
+		So(his[0].Err, ShouldBeNil)
+		So(his[0].Event, ShouldEqual, eB2A.ID())
+		So(his[1].Event, ShouldEqual, eA2B.ID())
+		So(his[2].Event.IsInvalid(), ShouldBeTrue)
+		So(his[2].From, ShouldEqual, bndB.ID())
+		So(his[2].To, ShouldEqual, bndA.ID())
+
+		sm.EnableHistory(0)
+		So(eA2B.Trigger(), ShouldBeNil)
+		So(sm.History(), ShouldBeEmpty)
+	})
+}
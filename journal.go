@@ -0,0 +1,247 @@
+package genesm
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// Journal errors
+var (
+	ErrJournalMismatch = errors.New("journal mismatch with state machine")
+	ErrNotRoot         = errors.New("state machine is not root")
+	ErrReplaying       = errors.New("state machine is replaying journal")
+)
+
+// journal operations
+const (
+	jopTrigger = "trigger"
+	jopReset   = "reset"
+	jopSet     = "set"
+)
+
+// journalEntry is a record in journal. each entry is written as a line of
+// JSON. payload of event and value of state are encoded by codec of journal.
+//
+// Machine is the path from root state machine to the state machine which the
+// event or state registed on. a non-negative number N means the sub state
+// machine of state N, and a negative number -N means region N-1.
+type journalEntry struct {
+	Time    time.Time
+	Op      string
+	Machine []int  `json:",omitempty"`
+	Serial  int    // register serial of event or state
+	Data    []byte `json:",omitempty"`
+}
+
+// journal write entries to writer
+type journal struct {
+	mux       sync.Mutex
+	enc       *json.Encoder
+	codec     Codec
+	replaying bool
+	applying  bool  // next transition is applied from an entry of journal
+	err       error // first error on writing
+}
+
+// SetJournal make state machine write each succeed transition (event ID and
+// payload), Reset and value update (StateBinder.Set) to w as an append-only
+// journal. a transition is written once its hook is passed, ahead of value
+// updates in observers and action. transitions of sub state machines and
+// regions are written as well. a nil w stop journaling.
+//
+// payloads and values are encoded by codec. nil codec means JSONCodec.
+//
+// only root state machine keep the journal. journal of a sub state machine or
+// region is set to its root.
+func (sm *StateMachine[O]) SetJournal(w io.Writer, codec Codec) {
+	if codec == nil {
+		codec = JSONCodec
+	}
+	jnl := &sm.root().jnl
+	jnl.mux.Lock()
+	defer jnl.mux.Unlock()
+	jnl.enc = nil
+	if w != nil {
+		jnl.enc = json.NewEncoder(w)
+	}
+	jnl.codec = codec
+	jnl.err = nil
+}
+
+// JournalErr get the first error on writing journal. once an error occured,
+// journaling is stopped until SetJournal is called again
+func (sm *StateMachine[O]) JournalErr() error {
+	jnl := &sm.root().jnl
+	jnl.mux.Lock()
+	defer jnl.mux.Unlock()
+	return jnl.err
+}
+
+// Replay read journal from r and apply each entry to state machine in order.
+// the state machine should be freshly built, with the same registrations
+// (states, events, sub state machines and regions in the same order) with
+// the one which produce the journal. codec must be the same one of journal.
+//
+// hooks and actions of events are run as a normal trigger. if silent is true,
+// observers of whole tree are not notified during replay. nothing is written
+// to journal of the state machine during replay.
+//
+// events which are triggered during replay but not from the journal (such as
+// events triggered in hooks, actions, observers and by timers) are dropped
+// with ErrReplaying. since their transitions are journaled as entries too.
+//
+// Replay must be called on root state machine. it stop on first failed
+// entry and return its error.
+func (sm *StateMachine[O]) Replay(r io.Reader, codec Codec, silent bool) error {
+	if sm.super != nil {
+		return ErrNotRoot
+	}
+	if codec == nil {
+		codec = JSONCodec
+	}
+	sm.jnl.mux.Lock()
+	sm.jnl.replaying = true
+	sm.jnl.mux.Unlock()
+	sm.silent.Store(silent)
+	defer func() {
+		sm.silent.Store(false)
+		sm.jnl.mux.Lock()
+		sm.jnl.replaying = false
+		sm.jnl.applying = false
+		sm.jnl.mux.Unlock()
+	}()
+	dec := json.NewDecoder(r)
+	for n := 0; ; n++ {
+		ent := journalEntry{}
+		if err := dec.Decode(&ent); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("journal entry %d: %w", n, err)
+		}
+		if err := sm.replayEntry(codec, &ent); err != nil {
+			return fmt.Errorf("journal entry %d: %w", n, err)
+		}
+	}
+}
+
+// replayEntry apply an entry of journal
+func (sm *StateMachine[O]) replayEntry(codec Codec, ent *journalEntry) error {
+	target := sm
+	for _, p := range ent.Machine {
+		target = target.pathNext(p)
+		if target == nil {
+			return ErrJournalMismatch
+		}
+	}
+	switch ent.Op {
+	case jopReset:
+		sm.jnl.apply()
+		return target.Reset()
+	case jopTrigger:
+		target.mux.RLock()
+		var ev eventAgent
+		if ent.Serial >= 0 && ent.Serial < len(target.evTab) {
+			ev = target.evTab[ent.Serial]
+		}
+		target.mux.RUnlock()
+		if ev == nil {
+			return ErrJournalMismatch
+		}
+		sm.jnl.apply()
+		return ev.replay(codec, ent.Data)
+	case jopSet:
+		target.mux.RLock()
+		defer target.mux.RUnlock()
+		if ent.Serial < 0 || ent.Serial >= len(target.stateTab) {
+			return ErrJournalMismatch
+		}
+		f, err := target.stateTab[ent.Serial].unmarshal(
+			codec, ent.Data, ent.Time)
+		if err != nil {
+			return err
+		}
+		f()
+		return nil
+	}
+	return ErrJournalMismatch
+}
+
+// pathNext get sub state machine or region by an element of path. it return
+// nil if there is no such one
+func (sm *StateMachine[O]) pathNext(p int) *StateMachine[O] {
+	sm.mux.RLock()
+	defer sm.mux.RUnlock()
+	if p >= 0 && p < len(sm.stateTab) {
+		return sm.stateTab[p].subMachine()
+	} else if p < 0 && -p-1 < len(sm.regions) {
+		return sm.regions[-p-1]
+	}
+	return nil
+}
+
+// isSilent check whether observers are silenced
+func (sm *StateMachine[O]) isSilent() bool {
+	return sm.root().silent.Load()
+}
+
+// apply allow the next transition during replay
+func (jnl *journal) apply() {
+	jnl.mux.Lock()
+	defer jnl.mux.Unlock()
+	jnl.applying = true
+}
+
+// admit check whether a transition is allowed. during replay, only the one
+// which is applied from an entry is allowed. nested ones are dropped
+func (jnl *journal) admit() bool {
+	jnl.mux.Lock()
+	defer jnl.mux.Unlock()
+	if !jnl.replaying {
+		return true
+	}
+	ok := jnl.applying
+	jnl.applying = false
+	return ok
+}
+
+// trigger write a succeed transition
+func (jnl *journal) trigger(path []int, tr transition) {
+	if tr.kind == trsReset {
+		jnl.write(path, jopReset, 0, nil, time.Now())
+		return
+	}
+	jnl.write(path, jopTrigger, tr.event.RegSerial, tr.payload, time.Now())
+}
+
+// set write a value update. upd is the update time of the value
+func (jnl *journal) set(path []int, serial int, val any, upd time.Time) {
+	jnl.write(path, jopSet, serial, val, upd)
+}
+
+// write encode and write an entry. value is encoded by codec if it is not
+// nil
+func (jnl *journal) write(
+	path []int, op string, serial int, val any, at time.Time,
+) {
+	jnl.mux.Lock()
+	defer jnl.mux.Unlock()
+	if jnl.enc == nil || jnl.replaying || jnl.err != nil {
+		return
+	}
+	ent := journalEntry{
+		Time:    at,
+		Op:      op,
+		Machine: path,
+		Serial:  serial,
+	}
+	if val != nil {
+		if ent.Data, jnl.err = jnl.codec.Marshal(val); jnl.err != nil {
+			return
+		}
+	}
+	jnl.err = jnl.enc.Encode(&ent)
+}
@@ -0,0 +1,139 @@
+package genesm
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// task is an example payload which can be encoded
+type task struct {
+	Code int
+	Msg  string
+}
+
+func TestJournal(t *testing.T) {
+	// state transition:
+	//   -> idle <-> job{ load -> save }
+	//   region: log counter
+	type machine struct {
+		sm      *StateMachine[string]
+		job     *StateMachine[string]
+		bndIdle StateBinder[string, string]
+		bndJob  StateBinder[string, string]
+		bndLoad StateBinder[string, int]
+		bndSave StateBinder[string, int]
+		bndLog  StateBinder[string, int]
+		eStart  EventP[string, string, string, task]
+		eStop   EventX[string, string, string]
+		eSave   EventX[string, int, int]
+		eLog    EventX[string, int, int]
+	}
+	build := func() *machine {
+		m := &machine{sm: NewStateMachine("owner")}
+		m.bndIdle = RegState(m.sm, "idle")
+		m.bndJob = RegState(m.sm, "")
+		m.job = NewSubStateMachine(m.bndJob)
+		m.bndLoad = RegState(m.job, 0)
+		m.bndSave = RegState(m.job, 0)
+		m.bndLog = RegState(NewRegion(m.sm), 0)
+		m.eStart = RegPayloadEvent[task](m.sm, m.bndIdle, m.bndJob)
+		m.eStop = RegEvent(m.sm, m.bndJob, m.bndIdle)
+		m.eSave = RegEvent(m.job, m.bndLoad, m.bndSave)
+		m.eLog = RegInternalEvent(m.bndLog.Parent(), m.bndLog)
+		m.eStart.SetAction(func(o, a, b string, p task, id StateID) {
+			m.bndJob.Set(p.Msg)
+		})
+		m.eLog.SetAction(func(o string, a, b int, id StateID) {
+			m.bndLog.Set(a + 1)
+		})
+		return m
+	}
+	Convey("Journal and replay test", t, func() {
+		for _, codec := range []Codec{nil, GobCodec} {
+			src := build()
+			buf := &bytes.Buffer{}
+			src.sm.SetJournal(buf, codec)
+			So(src.eStart.Trigger(task{Code: 1, Msg: "backup"}), ShouldBeNil)
+			So(src.eSave.Trigger(), ShouldBeNil)
+			So(src.eSave.Trigger(), ShouldNotBeNil) // not journaled
+			So(src.eLog.Trigger(), ShouldBeNil)
+			So(src.eLog.Trigger(), ShouldBeNil)
+			So(src.bndSave.Set(42), ShouldBeNil)
+			So(src.eStop.Trigger(), ShouldBeNil)
+			So(src.job.Reset(), ShouldBeNil)
+			So(src.eStart.Trigger(task{Msg: "restore"}), ShouldBeNil)
+			So(src.sm.JournalErr(), ShouldBeNil)
+
+			// silent replay
+			dst := build()
+			rec := []string{}
+			ctr := NewObsSyncController(0)
+			dst.bndJob.AddObserver(orderRecorder[string](ctr, "job", &rec))
+			So(dst.sm.Replay(bytes.NewReader(buf.Bytes()), codec, true),
+				ShouldBeNil)
+			So(rec, ShouldBeEmpty)
+			So(dst.sm.StateIDs(), ShouldResemble, []StateID{
+				dst.bndJob.ID(), dst.bndLoad.ID(), dst.bndLog.ID()})
+			So(dst.bndJob.Get(), ShouldEqual, "restore")
+			So(dst.bndLog.Get(), ShouldEqual, 2)
+			So(dst.bndSave.Get(), ShouldEqual, 42)
+			So(dst.bndSave.GetUpdTime().Equal(src.bndSave.GetUpdTime()),
+				ShouldBeTrue)
+			So(dst.eStop.Trigger(), ShouldBeNil)
+			So(rec, ShouldResemble, []string{"exit job"})
+
+			// replay with observers
+			dst = build()
+			rec = rec[:0]
+			dst.bndJob.AddObserver(orderRecorder[string](ctr, "job", &rec))
+			So(dst.sm.Replay(bytes.NewReader(buf.Bytes()), codec, false),
+				ShouldBeNil)
+			So(rec, ShouldResemble, []string{
+				"enter job", "exit job", "enter job"})
+		}
+
+		// journal mismatch with state machine
+		src := build()
+		buf := &bytes.Buffer{}
+		src.sm.SetJournal(buf, nil)
+		So(src.eSave.Trigger(), ShouldNotBeNil)
+		So(src.eStart.Trigger(task{}), ShouldBeNil)
+		So(src.eSave.Trigger(), ShouldBeNil)
+		other := NewStateMachine("owner")
+		RegState(other, 1)
+		err := other.Replay(bytes.NewReader(buf.Bytes()), nil, false)
+		So(errors.Is(err, ErrJournalMismatch), ShouldBeTrue)
+		So(src.job.Replay(bytes.NewReader(buf.Bytes()), nil, false),
+			ShouldEqual, ErrNotRoot)
+	})
+
+	Convey("Replay nested triggers", t, func() {
+		// A -> B -> C. B -> C is triggered in action of A -> B
+		build := func() (*StateMachine[string], []StateBinder[string, int],
+			Event) {
+			sm := NewStateMachine("owner")
+			sm.SetRunToCompletion(true)
+			bnds := []StateBinder[string, int]{
+				RegState(sm, 1), RegState(sm, 2), RegState(sm, 3)}
+			eAB := RegEvent(sm, bnds[0], bnds[1])
+			eBC := RegEvent(sm, bnds[1], bnds[2])
+			eAB.SetAction(func(o string, a, b int, id StateID) {
+				eBC.Trigger()
+			})
+			return sm, bnds, eAB
+		}
+		src, bnds, eAB := build()
+		buf := &bytes.Buffer{}
+		src.SetJournal(buf, nil)
+		So(eAB.Trigger(), ShouldBeNil)
+		So(src.StateID(), ShouldEqual, bnds[2].ID())
+		So(bytes.Count(buf.Bytes(), []byte(`"trigger"`)), ShouldEqual, 2)
+
+		dst, bnds, _ := build()
+		So(dst.Replay(bytes.NewReader(buf.Bytes()), nil, true), ShouldBeNil)
+		So(dst.StateID(), ShouldEqual, bnds[2].ID())
+	})
+}
@@ -0,0 +1,240 @@
+package genesm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sync"
+)
+
+// manager errors
+var (
+	ErrMachineExists   = errors.New("state machine already exists")
+	ErrMachineNotFound = errors.New("state machine not found")
+	ErrNoEvent         = errors.New("no such event on state machine")
+	ErrNilMachine      = errors.New("no state machine is created")
+)
+
+// Manager keep a large population of state machines keyed by id.
+//
+// machines are spread into shards by hash of id. each shard have own lock, so
+// operations on different shards never serialize on one lock. functions which
+// passed to Range are called without lock of shards, so it is safe to call
+// methods of Manager in them.
+type Manager[K comparable, O any] struct {
+	shards []mgrShard[K, O]
+	hash   func(K) uint32
+}
+
+// mgrShard is a shard of Manager
+type mgrShard[K comparable, O any] struct {
+	mux sync.RWMutex
+	sms map[K]*StateMachine[O]
+}
+
+// NewManager create a Manager with count of shards. hash is used to decide
+// shard of an id. if hash is nil, a default one base on FNV is used. which
+// format id by fmt if it is not a string. count of shards is at least 1.
+func NewManager[K comparable, O any](
+	shards int, hash func(K) uint32,
+) *Manager[K, O] {
+	if shards < 1 {
+		shards = 1
+	}
+	if hash == nil {
+		hash = defaultHash[K]
+	}
+	mg := &Manager[K, O]{
+		shards: make([]mgrShard[K, O], shards),
+		hash:   hash,
+	}
+	for i := range mg.shards {
+		mg.shards[i].sms = map[K]*StateMachine[O]{}
+	}
+	return mg
+}
+
+// defaultHash hash id by FNV-1a
+func defaultHash[K comparable](id K) uint32 {
+	h := fnv.New32a()
+	if s, ok := any(id).(string); ok {
+		h.Write([]byte(s))
+	} else {
+		fmt.Fprint(h, id)
+	}
+	return h.Sum32()
+}
+
+// shard get shard of an id
+func (mg *Manager[K, O]) shard(id K) *mgrShard[K, O] {
+	return &mg.shards[mg.hash(id)%uint32(len(mg.shards))]
+}
+
+// Create create a state machine by create function and keep it with id. it
+// return ErrMachineExists if id is already used, and ErrNilMachine if create
+// return nil. create is called under protected of the shard. such as:
+//
+//	sm, err := mg.Create("conn-1", func() *StateMachine[*Conn] {
+//		return tpl.New(conn).StateMachine
+//	})
+func (mg *Manager[K, O]) Create(
+	id K, create func() *StateMachine[O],
+) (*StateMachine[O], error) {
+	sd := mg.shard(id)
+	sd.mux.Lock()
+	defer sd.mux.Unlock()
+	if _, ok := sd.sms[id]; ok {
+		return nil, ErrMachineExists
+	}
+	sm := create()
+	if sm == nil {
+		return nil, ErrNilMachine
+	}
+	sd.sms[id] = sm
+	return sm, nil
+}
+
+// Get find state machine by id. it return nil if there is no such machine
+func (mg *Manager[K, O]) Get(id K) *StateMachine[O] {
+	sd := mg.shard(id)
+	sd.mux.RLock()
+	defer sd.mux.RUnlock()
+	return sd.sms[id]
+}
+
+// Len get count of state machines
+func (mg *Manager[K, O]) Len() int {
+	n := 0
+	for i := range mg.shards {
+		sd := &mg.shards[i]
+		sd.mux.RLock()
+		n += len(sd.sms)
+		sd.mux.RUnlock()
+	}
+	return n
+}
+
+// Range call f for each state machine until f return false. machines which
+// created or closed during ranging may or may not be visited
+func (mg *Manager[K, O]) Range(f func(id K, sm *StateMachine[O]) bool) {
+	for i := range mg.shards {
+		for _, it := range mg.shards[i].items() {
+			if !f(it.id, it.sm) {
+				return
+			}
+		}
+	}
+}
+
+// Close remove state machine from manager and close it. it return
+// ErrMachineNotFound if there is no such machine. see StateMachine.Close
+func (mg *Manager[K, O]) Close(id K) error {
+	sd := mg.shard(id)
+	sd.mux.Lock()
+	sm, ok := sd.sms[id]
+	delete(sd.sms, id)
+	sd.mux.Unlock()
+	if !ok {
+		return ErrMachineNotFound
+	}
+	return sm.Close()
+}
+
+// CloseAll remove and close all state machines
+func (mg *Manager[K, O]) CloseAll() {
+	for i := range mg.shards {
+		sd := &mg.shards[i]
+		sd.mux.Lock()
+		sms := sd.sms
+		sd.sms = map[K]*StateMachine[O]{}
+		sd.mux.Unlock()
+		for _, sm := range sms {
+			sm.Close()
+		}
+	}
+}
+
+// Broadcast trigger named event on all state machines which the state is
+// selected. state is a path of state which resolved on each machine, such as
+// "paying/card" (see StateMachine.LookupPath). the event is looked up on the
+// state machine (or sub state machine, region) which the state belong to. see
+// LookupEvent.
+//
+// it return count of machines which the event is triggered successfully, and
+// errors of failed machines. a machine which have no such event fail with
+// ErrNoEvent.
+func (mg *Manager[K, O]) Broadcast(state, event string) (int, map[K]error) {
+	return mg.BroadcastCtx(context.Background(), state, event)
+}
+
+// BroadcastCtx is similar as Broadcast, but trigger events with context. see
+// Event.TriggerCtx
+func (mg *Manager[K, O]) BroadcastCtx(
+	ctx context.Context, state, event string,
+) (int, map[K]error) {
+	n, errs := 0, map[K]error{}
+	mg.Range(func(id K, sm *StateMachine[O]) bool {
+		target := sm.selectedOf(sm.LookupPath(state))
+		if target == nil {
+			return true
+		}
+		ev, _ := sm.lookupName(target.smSerial, true, event).(Event)
+		if ev == nil {
+			errs[id] = ErrNoEvent
+		} else if err := ev.TriggerCtx(ctx); err != nil {
+			errs[id] = err
+		} else {
+			n++
+		}
+		return true
+	})
+	return n, errs
+}
+
+// Count count state machines per selected state. states are keyed by their
+// name paths (see StateMachine.StateNamePath), which could be passed to
+// Broadcast. so states of machines which built by the same registrations are
+// counted together, whether they are named or not. a machine is counted on
+// every selected state of it (sub states and states of regions)
+func (mg *Manager[K, O]) Count() map[string]int {
+	ret := map[string]int{}
+	mg.Range(func(id K, sm *StateMachine[O]) bool {
+		for _, p := range sm.StateNamePaths() {
+			ret[p]++
+		}
+		return true
+	})
+	return ret
+}
+
+// selectedOf find state machine in the tree which the state is selected on.
+// it return nil if the state is not selected
+func (sm *StateMachine[O]) selectedOf(id StateID) *StateMachine[O] {
+	sm.mux.RLock()
+	defer sm.mux.RUnlock()
+	var ret *StateMachine[O]
+	sm.eachSelectedOn(func(m *StateMachine[O]) {
+		if ret == nil && !id.IsInvalid() && m.stateOn == id {
+			ret = m
+		}
+	})
+	return ret
+}
+
+// mgrItem is a state machine with its id
+type mgrItem[K comparable, O any] struct {
+	id K
+	sm *StateMachine[O]
+}
+
+// items copy all state machines of the shard
+func (sd *mgrShard[K, O]) items() []mgrItem[K, O] {
+	sd.mux.RLock()
+	defer sd.mux.RUnlock()
+	ret := make([]mgrItem[K, O], 0, len(sd.sms))
+	for id, sm := range sd.sms {
+		ret = append(ret, mgrItem[K, O]{id: id, sm: sm})
+	}
+	return ret
+}
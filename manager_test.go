@@ -0,0 +1,113 @@
+package genesm
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestManager(t *testing.T) {
+	Convey("Manage state machines by id", t, func() {
+		spec, err := LoadSpec(strings.NewReader(orderSpec))
+		So(err, ShouldBeNil)
+		tpl, err := orderBuilder(itemsOf, nil).Template(spec)
+		So(err, ShouldBeNil)
+
+		mg := NewManager[int, int](4, nil)
+		wg := sync.WaitGroup{}
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				mg.Create(i, func() *StateMachine[int] {
+					return tpl.New(i % 2).StateMachine
+				})
+			}(i)
+		}
+		wg.Wait()
+		So(mg.Len(), ShouldEqual, 10)
+		_, err = mg.Create(3, func() *StateMachine[int] {
+			panic("should not be created")
+		})
+		So(err, ShouldEqual, ErrMachineExists)
+		So(mg.Get(3).GetOwner(), ShouldEqual, 1)
+		So(mg.Get(42), ShouldBeNil)
+		_, err = mg.Create(42, func() *StateMachine[int] { return nil })
+		So(err, ShouldEqual, ErrNilMachine)
+		So(mg.Get(42), ShouldBeNil)
+
+		n, errs := mg.Broadcast("cart", "checkout")
+		So(n, ShouldEqual, 5)
+		So(errs, ShouldHaveLength, 5)
+		So(errs[0].Error(), ShouldEqual, "empty cart")
+		So(mg.Count(), ShouldResemble, map[string]int{
+			"cart": 5, "paying": 5, "paying/card": 5,
+		})
+		n, errs = mg.Broadcast("paying/card", "switch")
+		So(n, ShouldEqual, 5)
+		So(errs, ShouldBeEmpty)
+		n, errs = mg.Broadcast("1/1", "nothing") // paying/bank
+		So(n, ShouldEqual, 0)
+		So(errs[1], ShouldEqual, ErrNoEvent)
+		n, errs = mg.Broadcast("paying/unknown", "switch")
+		So(n, ShouldEqual, 0)
+		So(errs, ShouldBeEmpty)
+
+		// paths of states
+		sm := mg.Get(1)
+		bank := sm.LookupPath("paying/bank")
+		So(sm.StateIDs()[1], ShouldEqual, bank)
+		So(sm.LookupPath("1/1"), ShouldEqual, bank)
+		So(sm.StatePath(bank), ShouldEqual, "1/1")
+		So(sm.StateNamePath(bank), ShouldEqual, "paying/bank")
+		So(sm.StatePaths(), ShouldResemble, []string{"1", "1/1"})
+		So(sm.StateNamePaths(), ShouldResemble,
+			[]string{"paying", "paying/bank"})
+		for _, p := range []string{"", "paying/bank/0", "cart/0", "9", "-1/0"} {
+			So(sm.LookupPath(p), ShouldEqual, STIDInvalid())
+		}
+		So(sm.StatePath(STIDInvalid()), ShouldEqual, "")
+		So(sm.StateNamePath(STIDInvalid()), ShouldEqual, "")
+
+		ids := []string{}
+		mg.Range(func(id int, sm *StateMachine[int]) bool {
+			ids = append(ids, fmt.Sprint(id))
+			return len(ids) < 3
+		})
+		So(ids, ShouldHaveLength, 3)
+
+		So(mg.Close(1), ShouldBeNil)
+		So(mg.Close(1), ShouldEqual, ErrMachineNotFound)
+		So(sm.Reset(), ShouldEqual, ErrClosed)
+		So(mg.Count()["paying"], ShouldEqual, 4)
+		mg.CloseAll()
+		So(mg.Len(), ShouldEqual, 0)
+		So(mg.Count(), ShouldBeEmpty)
+	})
+
+	Convey("Manager with string id", t, func() {
+		mg := NewManager[string, string](0, nil)
+		for _, id := range []string{"a", "b", "c"} {
+			_, err := mg.Create(id, func() *StateMachine[string] {
+				sm := NewStateMachine(id)
+				RegState(sm, 0)
+				rg := NewRegion(sm)
+				RegState(rg, 0, "idle")
+				RegState(rg, 0)
+				return sm
+			})
+			So(err, ShouldBeNil)
+		}
+		// unnamed states are counted together
+		So(mg.Count(), ShouldResemble, map[string]int{"0": 3, "-1/idle": 3})
+		rg := mg.Get("a").Regions()[0]
+		So(mg.Get("a").LookupPath("-1/1"), ShouldEqual, StateID{
+			SMSerial: rg.smSerial, RegSerial: 1,
+		})
+		So(mg.Get("a").LookupPath("-1/idle"), ShouldEqual, rg.StateID())
+		So(mg.Get("b").GetOwner(), ShouldEqual, "b")
+	})
+}
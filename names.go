@@ -0,0 +1,316 @@
+package genesm
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// nameKey is key of a named state or event in name table
+type nameKey struct {
+	smSerial uint32
+	event    bool
+	name     string
+}
+
+// nameTab keep names of states and events of a whole tree of state machines.
+// it is kept by root state machine, and have own lock. so names could be
+// resolved under protected of state machine (in hooks, actions and
+// observers). it is released together with the state machine.
+type nameTab struct {
+	mux    sync.RWMutex
+	shared namer // names which are not kept in table. see Template
+	states map[StateID]string
+	events map[EventID]string
+	byName map[nameKey]any // StateBinder or event object
+}
+
+// namer resolve names which are shared by trees of state machines, such as
+// instances of a Template. so names are not copied to each tree
+type namer interface {
+	stateName(id StateID) string
+	eventName(id EventID) string
+	lookup(smSerial uint32, event bool, name string) any
+}
+
+// names get name table of the tree of state machine
+func (sm *StateMachine[O]) names() *nameTab {
+	return &sm.root().nameTab
+}
+
+// reserveName reserve an optional name on a state machine before registing.
+// it return empty string if no name is given, and panic if the name is
+// already used on the state machine
+func reserveName[O any](sm *StateMachine[O], event bool, name []string) string {
+	if len(name) == 0 || name[0] == "" {
+		return ""
+	}
+	key := nameKey{smSerial: sm.smSerial, event: event, name: name[0]}
+	nt := sm.names()
+	nt.mux.Lock()
+	defer nt.mux.Unlock()
+	if _, ok := nt.byName[key]; ok || nt.shared != nil &&
+		nt.shared.lookup(sm.smSerial, event, name[0]) != nil {
+		panic("name \"" + name[0] + "\" is already used in StateMachine")
+	}
+	if nt.byName == nil {
+		nt.states = map[StateID]string{}
+		nt.events = map[EventID]string{}
+		nt.byName = map[nameKey]any{}
+	}
+	nt.byName[key] = nil
+	return name[0]
+}
+
+// bindStateName bind a reserved name with registed state
+func bindStateName[O any](
+	sm *StateMachine[O], id StateID, name string, sb any,
+) {
+	if name == "" {
+		return
+	}
+	nt := sm.names()
+	nt.mux.Lock()
+	defer nt.mux.Unlock()
+	nt.states[id] = name
+	nt.byName[nameKey{smSerial: id.SMSerial, name: name}] = sb
+}
+
+// bindEventName bind a reserved name with registed event
+func bindEventName[O any](
+	sm *StateMachine[O], id EventID, name string, ev any,
+) {
+	if name == "" {
+		return
+	}
+	nt := sm.names()
+	nt.mux.Lock()
+	defer nt.mux.Unlock()
+	nt.events[id] = name
+	nt.byName[nameKey{smSerial: id.SMSerial, event: true, name: name}] = ev
+}
+
+// lookupName find a named state or event which registed on a state machine
+// of the tree. smSerial is serial of that state machine
+func (sm *StateMachine[O]) lookupName(
+	smSerial uint32, event bool, name string,
+) any {
+	nt := sm.names()
+	nt.mux.RLock()
+	defer nt.mux.RUnlock()
+	key := nameKey{smSerial: smSerial, event: event, name: name}
+	if v, ok := nt.byName[key]; ok {
+		return v
+	} else if nt.shared != nil {
+		return nt.shared.lookup(smSerial, event, name)
+	}
+	return nil
+}
+
+// clearNames release all names of the tree of state machine
+func (sm *StateMachine[O]) clearNames() {
+	nt := sm.names()
+	nt.mux.Lock()
+	defer nt.mux.Unlock()
+	nt.shared, nt.states, nt.events, nt.byName = nil, nil, nil, nil
+}
+
+// StateName get name of a state. the state could be registed on the state
+// machine, or any state machine in the same tree (sub state machines and
+// regions). it return empty string if the state have no name. see RegState
+func (sm *StateMachine[O]) StateName(id StateID) string {
+	nt := sm.names()
+	nt.mux.RLock()
+	defer nt.mux.RUnlock()
+	return nt.stateName(id)
+}
+
+// stateName get name of a state. caller must hold lock of name table
+func (nt *nameTab) stateName(id StateID) string {
+	if name, ok := nt.states[id]; ok {
+		return name
+	} else if nt.shared != nil {
+		return nt.shared.stateName(id)
+	}
+	return ""
+}
+
+// EventName get name of an event. like StateName, the event could be registed
+// on any state machine of the same tree. it return empty string if the event
+// have no name. see RegEvent
+func (sm *StateMachine[O]) EventName(id EventID) string {
+	nt := sm.names()
+	nt.mux.RLock()
+	defer nt.mux.RUnlock()
+	if name, ok := nt.events[id]; ok {
+		return name
+	} else if nt.shared != nil {
+		return nt.shared.eventName(id)
+	}
+	return ""
+}
+
+// Describe get name of a state. it is formatted as same as StateID.String if
+// the state have no name
+func (sm *StateMachine[O]) Describe(id StateID) string {
+	if name := sm.StateName(id); name != "" {
+		return name
+	}
+	return id.String()
+}
+
+// DescribeEvent get name of an event. it is formatted as same as
+// EventID.String if the event have no name
+func (sm *StateMachine[O]) DescribeEvent(id EventID) string {
+	if name := sm.EventName(id); name != "" {
+		return name
+	}
+	return id.String()
+}
+
+// LookupState find a named state on state machine. it return nil if there is
+// no such state, or type of its value is not T. type of value need be
+// specified explicitly, such as:
+//
+//	bnd := LookupState[MyState](sm, "idle")
+//
+// only states registed on sm itself are found. use the sub state machine or
+// region to find their states.
+func LookupState[T any, O any](
+	sm *StateMachine[O], name string,
+) StateBinder[O, T] {
+	sb, _ := sm.lookupName(sm.smSerial, false, name).(StateBinder[O, T])
+	return sb
+}
+
+// LookupEvent find a named event on state machine. it return nil if there is
+// no such event, or the event do not implement E. E could be Event, EventX or
+// EventP, such as:
+//
+//	ev := LookupEvent[EventX[MyOwner, StateA, StateB]](sm, "start")
+//
+// only events registed on sm itself are found.
+func LookupEvent[E any, O any](sm *StateMachine[O], name string) E {
+	ev, _ := sm.lookupName(sm.smSerial, true, name).(E)
+	return ev
+}
+
+// StateNamePath format a state as StatePath, but elements of named states
+// are replaced by their names, such as "paying/card" or "-1/idle". elements
+// of unnamed states and regions are kept as in StatePath. so it could be
+// resolved on any state machine which built by the same registrations, see
+// LookupPath. it return empty string if the state is not registed in the tree
+// of sm.
+func (sm *StateMachine[O]) StateNamePath(id StateID) string {
+	m := sm.root().findMachine(id.SMSerial)
+	if m == nil {
+		return ""
+	}
+	m.mux.RLock()
+	valid := id.RegSerial >= 0 && id.RegSerial < len(m.stateTab)
+	m.mux.RUnlock()
+	if !valid {
+		return ""
+	}
+	return m.namePath(id.RegSerial)
+}
+
+// StateNamePaths get name paths of all selected states, in the same order of
+// StateIDs. see StateNamePath
+func (sm *StateMachine[O]) StateNamePaths() []string {
+	sm.mux.RLock()
+	defer sm.mux.RUnlock()
+	ret := []string{}
+	sm.eachSelectedOn(func(m *StateMachine[O]) {
+		ret = append(ret, m.namePath(m.stateOn.RegSerial))
+	})
+	return ret
+}
+
+// namePath format a state of the state machine as a name path. see
+// StateNamePath
+func (sm *StateMachine[O]) namePath(serial int) string {
+	nt := sm.names()
+	nt.mux.RLock()
+	defer nt.mux.RUnlock()
+	elem := func(id StateID) string {
+		if name := nt.stateName(id); name != "" {
+			return name
+		}
+		return strconv.Itoa(id.RegSerial)
+	}
+	elems := []string{elem(StateID{SMSerial: sm.smSerial, RegSerial: serial})}
+	for m := sm; m.super != nil; m = m.super {
+		if p := m.path[len(m.path)-1]; p >= 0 {
+			elems = append(elems, elem(StateID{
+				SMSerial: m.super.smSerial, RegSerial: p,
+			}))
+		} else {
+			elems = append(elems, strconv.Itoa(p))
+		}
+	}
+	for i, j := 0, len(elems)-1; i < j; i, j = i+1, j-1 {
+		elems[i], elems[j] = elems[j], elems[i]
+	}
+	return strings.Join(elems, "/")
+}
+
+// LookupPath find a state by path from root state machine. elements of path
+// are names of states, or elements of StatePath, so both of StatePath and
+// StateNamePath are accepted. such as "paying/card", "1/0" or "-1/idle". a
+// name is preferred if an element is both a name and a number. it return
+// invalid ID if there is no such state.
+func (sm *StateMachine[O]) LookupPath(path string) StateID {
+	m := sm.root()
+	elems := strings.Split(path, "/")
+	for i, e := range elems {
+		n, err := strconv.Atoi(e)
+		if sb, ok := m.lookupName(m.smSerial, false, e).(interface {
+			ID() StateID
+		}); ok {
+			n, err = sb.ID().RegSerial, nil
+		}
+		if err != nil {
+			return STIDInvalid()
+		}
+		m.mux.RLock()
+		var next *StateMachine[O]
+		switch {
+		case n >= len(m.stateTab) || n < -len(m.regions):
+		case i == len(elems)-1:
+			if n >= 0 {
+				m.mux.RUnlock()
+				return StateID{SMSerial: m.smSerial, RegSerial: n}
+			}
+		case n >= 0:
+			next = m.stateTab[n].subMachine()
+		default:
+			next = m.regions[-n-1]
+		}
+		m.mux.RUnlock()
+		if next == nil {
+			return STIDInvalid()
+		}
+		m = next
+	}
+	return STIDInvalid()
+}
+
+// String format the state as "SMSerial:RegSerial". names are kept by state
+// machines, use StateMachine.Describe to get name of the state
+func (s StateID) String() string {
+	if s.IsInvalid() {
+		return "invalid"
+	}
+	return fmt.Sprintf("%d:%d", s.SMSerial, s.RegSerial)
+}
+
+// String format the event as "SMSerial:RegSerial". use
+// StateMachine.DescribeEvent to get name of the event
+func (e EventID) String() string {
+	if e.IsInvalid() {
+		return "invalid"
+	}
+	return fmt.Sprintf("%d:%d", e.SMSerial, e.RegSerial)
+}
@@ -1,6 +1,7 @@
 package genesm
 
 import (
+	"context"
 	"errors"
 	"sync"
 	"sync/atomic"
@@ -37,10 +38,11 @@ var (
 // which help to check the issue.
 type Observer[O any, T any] interface {
 	startOb(owner O, id StateID, val T, selected bool) error
-	enter(owner O, id StateID, val T)
-	exit(owner O, id StateID, val T)
+	enter(owner O, id StateID, val T, ti *trigInfo)
+	exit(owner O, id StateID, val T, ti *trigInfo)
 	pick(owner O, id StateID, val T)
 	update(owner O, id StateID, val T)
+	release(id StateID)
 }
 
 // WarningType represent type of warning for handler of observer
@@ -54,6 +56,7 @@ const (
 	ObWFrameTimeout  WarningType = "frame_timeout"
 	ObWFrameSkip     WarningType = "frame_skipped"
 	ObWMaxBlocking   WarningType = "max_hander_blocking"
+	ObWEventDropped  WarningType = "event_dropped"
 )
 
 // ObWarning is a notification to report failures on handler of observer
@@ -101,6 +104,15 @@ type ObsHandlerEvent[O any, T any] interface {
 	Update(owner O, id StateID, val T)
 }
 
+// ObsHandlerPayload is an optional interface of ObsHandlerEvent. if a handler
+// implement it, EnterWith and ExitWith will be called instead of Enter and
+// Exit. the payload is the one which passed to the event that cause the
+// transition. it is nil if the event carry no payload.
+type ObsHandlerPayload[O any, T any] interface {
+	EnterWith(owner O, id StateID, val T, payload any)
+	ExitWith(owner O, id StateID, val T, payload any)
+}
+
 // ObsHandlerFrames represent handler of time-based observer. developer need
 // implement this interface to handle each frame
 type ObsHandlerFrames[O any, T any] interface {
@@ -131,6 +143,32 @@ func ObsEventFuncs[O any, T any](
 	}
 }
 
+// simplePayloadOb is a simple ObsHandlerEvent implementation that also
+// implement ObsHandlerPayload
+type simplePayloadOb[O any, T any] struct {
+	simpleEventOb[O, T]
+	enterWith func(owner O, id StateID, val T, payload any)
+	exitWith  func(owner O, id StateID, val T, payload any)
+}
+
+// ObsEventPayloadFuncs create ObsHandlerEvent from ordinary functions. the
+// handler receive payload of event on enter and exit
+func ObsEventPayloadFuncs[O any, T any](
+	enter func(owner O, id StateID, val T, payload any),
+	exit func(owner O, id StateID, val T, payload any),
+	pick func(owner O, id StateID, val T),
+	update func(owner O, id StateID, val T),
+) ObsHandlerEvent[O, T] {
+	return &simplePayloadOb[O, T]{
+		simpleEventOb: simpleEventOb[O, T]{
+			pick:   pick,
+			update: update,
+		},
+		enterWith: enter,
+		exitWith:  exit,
+	}
+}
+
 // simpleFrameOb is a simple ObsHandlerFrames that create from pure function
 type simpleFrameOb[O any, T any] func(
 	owner O, ev FrameEvent, stateID StateID, skipped int64, val T)
@@ -148,8 +186,12 @@ func ObsFrameFunc[O any, T any](
 type ObsController interface {
 	// Warning return a channel that report warning of handler
 	Warning() <-chan ObWarning
+	// Close stop the controller. handlers which already queued are still
+	// executed, but handlers queued later are dropped
+	Close()
 
 	run(func())
+	runCtx(context.Context, func()) error
 	packEvent(
 		stateID StateID, wtimeout WarningType,
 		f func(), runHook func(), retHook func(timeout bool),
@@ -174,7 +216,9 @@ type ObsController interface {
 // SizeEventQueue is size of event execute queue. if a handler of event is
 // blocked, next event will waiting in the queue until previous handler return
 // or timeout. if queue is full, whole event chain under state machine will be
-// blocked. default value of SizeEventQueue is 5.
+// blocked. default value of SizeEventQueue is 5. an event which triggered with
+// context (see Event.TriggerCtx) will give up waiting once the context is done.
+// in this case, the observer event is dropped and reported as a warning.
 //
 // SizeWarnChan is length of channel to report warning. default value is 3. if
 // channel is full, the message of warning will be lost.
@@ -193,6 +237,8 @@ type obsControllerImpl struct {
 	maxBlock        uint32         // max blocked handler.
 	blockingTimeout time.Duration  // execute timeout for waiting a handler
 	warnChan        chan ObWarning // channel for warning report
+	quit            chan struct{}  // closed once controller is closed
+	quitOnce        sync.Once
 }
 
 // NewObsController create a new ObsController
@@ -212,6 +258,7 @@ func NewObsController(cfg ObsControlCfg) ObsController {
 		blockingTimeout: cfg.Timeout,
 		maxBlock:        cfg.MaxBlock,
 		warnChan:        make(chan ObWarning, cfg.SizeWarnChan),
+		quit:            make(chan struct{}),
 	}
 	ret.init()
 	return ret
@@ -244,6 +291,7 @@ type eventObCollector struct {
 	bindstat int32
 	stateID  StateID
 	ctr      ObsController
+	ownCtr   bool // ctr is created by observer itself
 }
 
 // eventObAgent implamented a event-based observer
@@ -252,6 +300,7 @@ type eventObAgent[O any, T any] struct {
 	//state StateBinder[O, T]
 	hook *ObserveProtectedHook[O, T]
 	obIf ObsHandlerEvent[O, T]
+	obPl ObsHandlerPayload[O, T] // nil if obIf not support payload
 }
 
 // obTickable indecate a generic frameObAgent to adapt to common ticker
@@ -264,7 +313,8 @@ type obTickable interface {
 // ticker to trigger them.
 //
 // ObsFrameTicker only send ticker to observer that State have been selected by
-// StateMachine
+// StateMachine. since each region or sub state machine keep its own selected
+// state, observers of them are ticked together.
 type ObsFrameTicker interface {
 	// Stop stop the ticker
 	Stop()
@@ -283,6 +333,7 @@ type ObsFrameTicker interface {
 	TickCount() int64
 
 	switchTo(ob obTickable, stateID StateID)
+	release(ob obTickable, stateID StateID)
 }
 
 // obsFrameTicker is a ObsFrameTicker implementation
@@ -331,18 +382,22 @@ func CreateEventObserver[O any, T any](
 	if ob == nil {
 		panic("ob can not be nil")
 	}
-	if ctrl == nil {
+	ownCtr := ctrl == nil
+	if ownCtr {
 		ctrl = NewObsController(ObsControlCfg{}) // use separate controller
 	}
 	if hook == nil {
 		hook = &ObserveProtectedHook[O, T]{} // use default hook
 	}
+	obPl, _ := ob.(ObsHandlerPayload[O, T])
 	return &eventObAgent[O, T]{
 		eventObCollector: eventObCollector{
-			ctr: ctrl,
+			ctr:    ctrl,
+			ownCtr: ownCtr,
 		},
 		hook: hook,
 		obIf: ob,
+		obPl: obPl,
 	}
 }
 
@@ -357,7 +412,8 @@ func CreateFrameObserver[O any, T any](
 	if ob == nil {
 		panic("ob can not be nil")
 	}
-	if ctrl == nil {
+	ownCtr := ctrl == nil
+	if ownCtr {
 		ctrl = NewObsController(ObsControlCfg{}) // use separate controller
 	}
 	if hook == nil {
@@ -365,7 +421,8 @@ func CreateFrameObserver[O any, T any](
 	}
 	return &frameObAgent[O, T]{
 		eventObCollector: eventObCollector{
-			ctr: ctrl,
+			ctr:    ctrl,
+			ownCtr: ownCtr,
 		},
 		hook:   hook,
 		obIf:   ob,
@@ -396,6 +453,27 @@ func (sob *simpleEventOb[O, T]) Update(owner O, id StateID, val T) {
 	}
 }
 
+func (sob *simplePayloadOb[O, T]) Enter(owner O, id StateID, val T) {
+	sob.EnterWith(owner, id, val, nil)
+}
+func (sob *simplePayloadOb[O, T]) Exit(owner O, id StateID, val T) {
+	sob.ExitWith(owner, id, val, nil)
+}
+func (sob *simplePayloadOb[O, T]) EnterWith(
+	owner O, id StateID, val T, payload any,
+) {
+	if sob.enterWith != nil {
+		sob.enterWith(owner, id, val, payload)
+	}
+}
+func (sob *simplePayloadOb[O, T]) ExitWith(
+	owner O, id StateID, val T, payload any,
+) {
+	if sob.exitWith != nil {
+		sob.exitWith(owner, id, val, payload)
+	}
+}
+
 func (sob simpleFrameOb[O, T]) Frame(
 	owner O, evt FrameEvent, id StateID, skipped int64, val T,
 ) {
@@ -498,6 +576,16 @@ func (tk *obsFrameTicker) switchTo(ob obTickable, stateID StateID) {
 	}
 }
 
+// release remove observer from ticker if it is still the active observer of
+// its state machine
+func (tk *obsFrameTicker) release(ob obTickable, stateID StateID) {
+	tk.mux.Lock()
+	defer tk.mux.Unlock()
+	if cur, ok := tk.obs[stateID.SMSerial]; ok && cur == ob {
+		delete(tk.obs, stateID.SMSerial)
+	}
+}
+
 // --------------- ObsController implementation ---------------
 
 // init initialize controller
@@ -505,7 +593,16 @@ func (ctrl *obsControllerImpl) init() {
 	// start event thread
 	go func() {
 		for {
-			exec := <-ctrl.evtCh
+			var exec func()
+			select {
+			case exec = <-ctrl.evtCh:
+			case <-ctrl.quit:
+				select {
+				case exec = <-ctrl.evtCh: // drain queued handlers before quit
+				default:
+					return
+				}
+			}
 			<-ctrl.evtRt
 			atomic.AddInt32(&ctrl.blockedCount, 1) //>>blockedCount
 			go func(xc func()) {
@@ -516,9 +613,46 @@ func (ctrl *obsControllerImpl) init() {
 	ctrl.evtRt <- struct{}{}
 }
 
-// run run a function in observer thread
+// run run a function in observer thread. it is dropped if controller is
+// closed
 func (ctrl *obsControllerImpl) run(f func()) {
-	ctrl.evtCh <- f
+	select {
+	case <-ctrl.quit: // a ready send must not win over quit after closing
+		return
+	default:
+	}
+	select {
+	case ctrl.evtCh <- f:
+	case <-ctrl.quit:
+	}
+}
+
+// runCtx run a function in observer thread. it give up if ctx is done before
+// the function be queued
+func (ctrl *obsControllerImpl) runCtx(ctx context.Context, f func()) error {
+	select {
+	case <-ctrl.quit:
+		return nil
+	default:
+	}
+	select {
+	case ctrl.evtCh <- f:
+		return nil
+	default:
+	}
+	select {
+	case ctrl.evtCh <- f:
+		return nil
+	case <-ctrl.quit:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close stop event thread of controller
+func (ctrl *obsControllerImpl) Close() {
+	ctrl.quitOnce.Do(func() { close(ctrl.quit) })
 }
 
 // warn send a warning
@@ -593,6 +727,12 @@ func (sctrl *obsSyncControllerImpl) run(f func()) {
 	f()
 }
 
+// runCtx run a function directly. context is ignored since nothing to wait
+func (sctrl *obsSyncControllerImpl) runCtx(_ context.Context, f func()) error {
+	f()
+	return nil
+}
+
 // warn send a warning
 func (sctrl *obsSyncControllerImpl) warn(w WarningType, stateID StateID) {
 	select {
@@ -628,6 +768,10 @@ func (sctrl *obsSyncControllerImpl) Warning() <-chan ObWarning {
 	return sctrl.warnChan
 }
 
+// Close do nothing since synchonous controller have no thread
+func (sctrl *obsSyncControllerImpl) Close() {
+}
+
 // --------------- eventObCollector methods ---------------
 
 // initOb init event processor
@@ -639,6 +783,31 @@ func (eoc *eventObCollector) initOb(stateID StateID) error {
 	return nil
 }
 
+// releaseCtr stop controller if it is created by observer itself
+func (eoc *eventObCollector) releaseCtr() {
+	if eoc.ownCtr {
+		eoc.ctr.Close()
+	}
+}
+
+// dispatch run a event handler on controller. if context of trigger is done
+// before the handler be queued, the handler is dropped and a warning is sent
+func (eoc *eventObCollector) dispatch(
+	ti *trigInfo, wtimeout WarningType, f func(),
+) {
+	pk := eoc.ctr.packEvent(eoc.stateID, wtimeout, f, nil, nil)
+	if ti == nil || ti.ctx == nil {
+		eoc.ctr.run(pk)
+		return
+	}
+	if err := eoc.ctr.runCtx(ti.ctx, pk); err != nil {
+		eoc.ctr.warn(ObWEventDropped, eoc.stateID)
+		if ti.err == nil {
+			ti.err = err
+		}
+	}
+}
+
 // --------------- Event based Observer implementation ---------------
 
 func (eoa *eventObAgent[O, T]) startOb(
@@ -647,7 +816,9 @@ func (eoa *eventObAgent[O, T]) startOb(
 	return eoa.initOb(id)
 }
 
-func (eoa *eventObAgent[O, T]) enter(owner O, id StateID, val T) {
+func (eoa *eventObAgent[O, T]) enter(
+	owner O, id StateID, val T, ti *trigInfo,
+) {
 	var newval T
 	skip := false
 	if eoa.hook != nil && eoa.hook.enter != nil {
@@ -658,12 +829,18 @@ func (eoa *eventObAgent[O, T]) enter(owner O, id StateID, val T) {
 	} else {
 		newval = val
 	}
-	eoa.ctr.run(eoa.ctr.packEvent(eoa.stateID, ObWEnterTimeout, func() {
-		eoa.obIf.Enter(owner, id, newval)
-	}, nil, nil))
+	eoa.dispatch(ti, ObWEnterTimeout, func() {
+		if eoa.obPl != nil {
+			eoa.obPl.EnterWith(owner, id, newval, ti.getPayload())
+		} else {
+			eoa.obIf.Enter(owner, id, newval)
+		}
+	})
 }
 
-func (eoa *eventObAgent[O, T]) exit(owner O, id StateID, val T) {
+func (eoa *eventObAgent[O, T]) exit(
+	owner O, id StateID, val T, ti *trigInfo,
+) {
 	var newval T
 	skip := false
 	if eoa.hook != nil && eoa.hook.exit != nil {
@@ -674,9 +851,13 @@ func (eoa *eventObAgent[O, T]) exit(owner O, id StateID, val T) {
 	} else {
 		newval = val
 	}
-	eoa.ctr.run(eoa.ctr.packEvent(eoa.stateID, ObWExitTimeout, func() {
-		eoa.obIf.Exit(owner, id, newval)
-	}, nil, nil))
+	eoa.dispatch(ti, ObWExitTimeout, func() {
+		if eoa.obPl != nil {
+			eoa.obPl.ExitWith(owner, id, newval, ti.getPayload())
+		} else {
+			eoa.obIf.Exit(owner, id, newval)
+		}
+	})
 }
 
 func (eoa *eventObAgent[O, T]) pick(owner O, id StateID, val T) {
@@ -711,6 +892,10 @@ func (eoa *eventObAgent[O, T]) update(owner O, id StateID, val T) {
 	}, nil, nil))
 }
 
+func (eoa *eventObAgent[O, T]) release(id StateID) {
+	eoa.releaseCtr()
+}
+
 // --------------- Time based Observer implementation ---------------
 
 // skipWarn implement obTickable interface
@@ -765,7 +950,7 @@ func (foa *frameObAgent[O, T]) startOb(
 	return nil
 }
 
-func (foa *frameObAgent[O, T]) enter(owner O, id StateID, val T) {
+func (foa *frameObAgent[O, T]) enter(owner O, id StateID, val T, _ *trigInfo) {
 	if foa.hook != nil && foa.hook.enter != nil {
 		val, skip := foa.hook.enter(owner, id, val)
 		if !skip {
@@ -781,7 +966,8 @@ func (foa *frameObAgent[O, T]) enter(owner O, id StateID, val T) {
 	foa.ticker.switchTo(foa, id)
 }
 
-func (foa *frameObAgent[O, T]) exit(owner O, id StateID, val T) {
+func (foa *frameObAgent[O, T]) exit(owner O, id StateID, val T, _ *trigInfo) {
+	foa.ticker.release(foa, id)
 	if foa.hook != nil && foa.hook.exit != nil {
 		val, skip := foa.hook.exit(owner, id, val)
 		if !skip {
@@ -795,6 +981,11 @@ func (foa *frameObAgent[O, T]) exit(owner O, id StateID, val T) {
 	foa.owner = owner
 }
 
+func (foa *frameObAgent[O, T]) release(id StateID) {
+	foa.ticker.release(foa, id)
+	foa.releaseCtr()
+}
+
 func (foa *frameObAgent[O, T]) pick(owner O, id StateID, val T) {
 	if foa.hook != nil && foa.hook.pick != nil {
 		val, skip := foa.hook.pick(owner, id, val)
@@ -2,6 +2,7 @@ package genesm
 
 import (
 	"runtime"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -73,9 +74,9 @@ func TestEventObserver(t *testing.T) {
 		owner := "XEventOwner"
 		ob.startOb(owner, StateID{1, 1}, 0, true)
 		So(ob.(*eventObAgent[string, int]).stateID, ShouldEqual, StateID{1, 1})
-		ob.enter(owner, StateID{1, 1}, 1)
+		ob.enter(owner, StateID{1, 1}, 1, nil)
 		So(hookRet[0], ShouldBeFalse)
-		ob.exit(owner, StateID{1, 1}, 2)
+		ob.exit(owner, StateID{1, 1}, 2, nil)
 		So(hookRet[1], ShouldBeFalse)
 		ob.pick(owner, StateID{1, 1}, 3)
 		So(hookRet[2], ShouldBeFalse)
@@ -100,10 +101,10 @@ func TestEventObserver(t *testing.T) {
 			shiftF = func() {
 				time.Sleep(500 * time.Millisecond)
 			}
-			ob.enter(owner, StateID{1, 1}, 4)
-			ob.enter(owner, StateID{1, 1}, 5)
-			ob.enter(owner, StateID{1, 1}, 6)
-			ob.enter(owner, StateID{1, 1}, 7)
+			ob.enter(owner, StateID{1, 1}, 4, nil)
+			ob.enter(owner, StateID{1, 1}, 5, nil)
+			ob.enter(owner, StateID{1, 1}, 6, nil)
+			ob.enter(owner, StateID{1, 1}, 7, nil)
 			time.Sleep(2 * time.Second)
 			// Timeout x 4 + max Blocking x 2
 			So(wrCount == 6, ShouldBeTrue)
@@ -136,8 +137,8 @@ func TestEventObserver(t *testing.T) {
 		So(ob, ShouldNotBeNil)
 		owner := "XEventOwner-NoHook"
 		ob.startOb(owner, StateID{1, 2}, 0, true)
-		ob.enter(owner, StateID{1, 2}, 1)
-		ob.exit(owner, StateID{1, 2}, 2)
+		ob.enter(owner, StateID{1, 2}, 1, nil)
+		ob.exit(owner, StateID{1, 2}, 2, nil)
 		ob.pick(owner, StateID{1, 2}, 3)
 		ob.update(owner, StateID{1, 2}, 4)
 		runtime.Gosched()
@@ -231,21 +232,21 @@ func TestFrameObserver(t *testing.T) {
 		ob2.startOb(owner, StateID{1, 3}, 200, false)
 
 		time.Sleep(200 * time.Millisecond)
-		ob0.enter(owner, StateID{1, 1}, 1)
+		ob0.enter(owner, StateID{1, 1}, 1, nil)
 		time.Sleep(200 * time.Millisecond)
 		ob0.pick(owner, StateID{1, 1}, 2)
 		time.Sleep(200 * time.Millisecond)
 		ob0.update(owner, StateID{1, 1}, 3)
 		time.Sleep(200 * time.Millisecond)
-		ob0.exit(owner, StateID{1, 1}, 4)
-		ob1.enter(owner, StateID{1, 2}, 101)
+		ob0.exit(owner, StateID{1, 1}, 4, nil)
+		ob1.enter(owner, StateID{1, 2}, 101, nil)
 		time.Sleep(200 * time.Millisecond)
 		ob1.pick(owner, StateID{1, 2}, 102)
 		time.Sleep(200 * time.Millisecond)
 		ob1.update(owner, StateID{1, 2}, 103)
 		time.Sleep(200 * time.Millisecond)
-		ob1.exit(owner, StateID{1, 2}, 104)
-		ob2.enter(owner, StateID{1, 3}, 201)
+		ob1.exit(owner, StateID{1, 2}, 104, nil)
+		ob2.enter(owner, StateID{1, 3}, 201, nil)
 		time.Sleep(200 * time.Millisecond)
 
 		So(hookRet[0], ShouldBeFalse)
@@ -291,7 +292,7 @@ func TestFrameObserver(t *testing.T) {
 		So(tk.TotalSkipped(), ShouldBeGreaterThan, 0)
 
 		// Test ticker modify
-		ob0.enter(owner, StateID{1, 1}, 9)
+		ob0.enter(owner, StateID{1, 1}, 9, nil)
 		time.Sleep(200 * time.Millisecond)
 		tk.Stop()
 		t.Log("STOPPED")
@@ -305,6 +306,38 @@ func TestFrameObserver(t *testing.T) {
 		So(tk.TickCount()-tcs, ShouldEqual, 20)
 		So(20-tk.TotalFrames()-fms <= 1, ShouldBeTrue)
 	})
+
+	// a sub state machine have no selected state once its upper state exit. so
+	// frame observer of sub state is detached from ticker on exit, rather than
+	// replaced by the next selected state
+	Convey("Frame observer of sub state machine", t, func() {
+		sm := NewStateMachine("owner")
+		bndA, bndB := RegState(sm, 0), RegState(sm, 0)
+		bndB1 := RegState(NewSubStateMachine(bndB), 1)
+		eA2B, eB2A := RegEvent(sm, bndA, bndB), RegEvent(sm, bndB, bndA)
+		tk, err := CreateObsFrameTicker(100)
+		So(err, ShouldBeNil)
+		defer tk.Stop()
+		frames := int32(0)
+		So(bndB1.AddObserver(CreateFrameObserver(NewObsSyncController(0), tk,
+			ObsFrameFunc(func(
+				owner string, ev FrameEvent, id StateID, skipped int64, v int,
+			) {
+				atomic.AddInt32(&frames, 1)
+			}), nil)), ShouldBeNil)
+
+		So(eA2B.Trigger(), ShouldBeNil)
+		time.Sleep(50 * time.Millisecond)
+		So(atomic.LoadInt32(&frames), ShouldBeGreaterThan, 0)
+		So(eB2A.Trigger(), ShouldBeNil)
+		time.Sleep(20 * time.Millisecond)
+		n := atomic.LoadInt32(&frames)
+		time.Sleep(50 * time.Millisecond)
+		So(atomic.LoadInt32(&frames), ShouldEqual, n)
+		So(eA2B.Trigger(), ShouldBeNil)
+		time.Sleep(50 * time.Millisecond)
+		So(atomic.LoadInt32(&frames), ShouldBeGreaterThan, n)
+	})
 }
 
 func TestSyncController(t *testing.T) {
@@ -370,11 +403,11 @@ func TestSyncController(t *testing.T) {
 			), nil)
 
 		// Test sync with hook
-		ob1.enter("owner", StateID{1, 1}, 1)
+		ob1.enter("owner", StateID{1, 1}, 1, nil)
 		So(gothr[0], ShouldBeTrue)
 		So(gothr[4], ShouldBeFalse)
 		So(gothr[5], ShouldBeTrue)
-		ob1.exit("owner", StateID{1, 1}, 2)
+		ob1.exit("owner", StateID{1, 1}, 2, nil)
 		So(gothr[1], ShouldBeTrue)
 		So(gothr[6], ShouldBeTrue)
 		ob1.pick("owner", StateID{1, 1}, 3)
@@ -387,8 +420,8 @@ func TestSyncController(t *testing.T) {
 		So(gothr[4], ShouldBeFalse)
 
 		// Test sync without hook
-		ob2.enter("owner", StateID{1, 1}, 1)
-		ob2.exit("owner", StateID{1, 1}, 2)
+		ob2.enter("owner", StateID{1, 1}, 1, nil)
+		ob2.exit("owner", StateID{1, 1}, 2, nil)
 		ob2.pick("owner", StateID{1, 1}, 3)
 		ob2.update("owner", StateID{1, 1}, 4)
 		So(gothr[9], ShouldBeTrue)
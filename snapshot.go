@@ -0,0 +1,229 @@
+package genesm
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// SnapshotVersion is version of snapshot format which Snapshot produce
+const SnapshotVersion = 1
+
+// Snapshot errors
+var (
+	ErrSnapshotVersion  = errors.New("unsupported snapshot version")
+	ErrSnapshotMismatch = errors.New("snapshot mismatch with state machine")
+)
+
+// Codec encode and decode values of snapshot
+type Codec interface {
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+}
+
+// JSONCodec is a Codec base on encoding/json. it is the default codec
+var JSONCodec Codec = jsonCodec{}
+
+// GobCodec is a Codec base on encoding/gob
+var GobCodec Codec = gobCodec{}
+
+// jsonCodec implement Codec with encoding/json
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v any) ([]byte, error) { return json.Marshal(v) }
+
+func (jsonCodec) Unmarshal(data []byte, v any) error {
+	return json.Unmarshal(data, v)
+}
+
+// gobCodec implement Codec with encoding/gob
+type gobCodec struct{}
+
+func (gobCodec) Marshal(v any) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	if err := gob.NewEncoder(buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gobCodec) Unmarshal(data []byte, v any) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+// snapshot is the serialized form of a state machine
+type snapshot struct {
+	Version int
+	Machine machineSnap
+}
+
+// machineSnap is snapshot of a state machine and its descendants
+type machineSnap struct {
+	Selected int // register serial of selected (or last selected) state
+	States   []stateSnap
+	Regions  []machineSnap
+}
+
+// stateSnap is snapshot of a registed state
+type stateSnap struct {
+	Value   []byte
+	UpdTime time.Time
+	Sub     *machineSnap `json:",omitempty"` // snapshot of sub state machine
+}
+
+// Snapshot capture selected states and values of all registed states. sub
+// state machines and regions are included. last selected states of inactive
+// sub state machines are kept as well.
+//
+// values are encoded by codec. nil codec means JSONCodec. the snapshot itself
+// is encoded by the same codec.
+func (sm *StateMachine[O]) Snapshot(codec Codec) ([]byte, error) {
+	if codec == nil {
+		codec = JSONCodec
+	}
+	sm.mux.RLock()
+	defer sm.mux.RUnlock()
+	snap := snapshot{Version: SnapshotVersion}
+	if err := sm.snapshotOn(codec, &snap.Machine); err != nil {
+		return nil, err
+	}
+	return codec.Marshal(&snap)
+}
+
+// Restore rebuild selected states and values from a snapshot. the state
+// machine must have the same registrations (states, sub state machines and
+// regions in the same order) with the one which produce the snapshot.
+//
+// codec must be the same one which produce the snapshot. nil codec means
+// JSONCodec. nothing is changed if any error is returned.
+//
+// observers are notified as a normal transition. selected states of whole
+// chain are exited at first, then values are updated and restored states are
+// entered.
+func (sm *StateMachine[O]) Restore(data []byte, codec Codec) error {
+	if codec == nil {
+		codec = JSONCodec
+	}
+	snap := snapshot{}
+	if err := codec.Unmarshal(data, &snap); err != nil {
+		return err
+	} else if snap.Version != SnapshotVersion {
+		return ErrSnapshotVersion
+	}
+	sm.mux.Lock()
+	defer sm.mux.Unlock()
+	if sm.isClosed() {
+		return ErrClosed
+	}
+	apply, err := sm.restoreOn(codec, &snap.Machine)
+	if err != nil {
+		return err
+	}
+	ti := &trigInfo{}
+	if sm.active {
+		sm.leaveOn(ti)
+	}
+	apply()
+	if sm.active {
+		sm.arriveOn(true, ti)
+	}
+	return ti.err
+}
+
+// snapshotOn capture snapshot recursively. caller must hold the lock
+func (sm *StateMachine[O]) snapshotOn(codec Codec, ms *machineSnap) error {
+	ms.Selected = sm.stateOn.RegSerial
+	ms.States = make([]stateSnap, len(sm.stateTab))
+	for i, st := range sm.stateTab {
+		val, upd, err := st.marshal(codec)
+		if err != nil {
+			return err
+		}
+		ms.States[i] = stateSnap{Value: val, UpdTime: upd}
+		if sub := st.subMachine(); sub != nil {
+			ms.States[i].Sub = &machineSnap{}
+			sub.mux.RLock()
+			err = sub.snapshotOn(codec, ms.States[i].Sub)
+			sub.mux.RUnlock()
+			if err != nil {
+				return err
+			}
+		}
+	}
+	ms.Regions = make([]machineSnap, len(sm.regions))
+	for i, rg := range sm.regions {
+		rg.mux.RLock()
+		err := rg.snapshotOn(codec, &ms.Regions[i])
+		rg.mux.RUnlock()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// restoreOn check snapshot and decode values recursively. it return a
+// function to apply the snapshot. caller must hold the lock on both call and
+// apply
+func (sm *StateMachine[O]) restoreOn(
+	codec Codec, ms *machineSnap,
+) (func(), error) {
+	if len(ms.States) != len(sm.stateTab) ||
+		len(ms.Regions) != len(sm.regions) ||
+		(len(sm.stateTab) != 0 &&
+			(ms.Selected < 0 || ms.Selected >= len(sm.stateTab))) {
+		return nil, ErrSnapshotMismatch
+	}
+	apply := []func(){}
+	for i, st := range sm.stateTab {
+		f, err := st.unmarshal(codec, ms.States[i].Value, ms.States[i].UpdTime)
+		if err != nil {
+			return nil, err
+		}
+		apply = append(apply, f)
+		sub := st.subMachine()
+		if (sub == nil) != (ms.States[i].Sub == nil) {
+			return nil, ErrSnapshotMismatch
+		} else if sub != nil {
+			f, err := sub.restoreLocked(codec, ms.States[i].Sub)
+			if err != nil {
+				return nil, err
+			}
+			apply = append(apply, f)
+		}
+	}
+	for i, rg := range sm.regions {
+		f, err := rg.restoreLocked(codec, &ms.Regions[i])
+		if err != nil {
+			return nil, err
+		}
+		apply = append(apply, f)
+	}
+	selected := StateID{SMSerial: sm.smSerial, RegSerial: ms.Selected}
+	return func() {
+		for _, f := range apply {
+			f()
+		}
+		sm.stateOn = selected
+		sm.touched = true
+	}, nil
+}
+
+// restoreLocked is similar as restoreOn, but acquire the lock by itself
+func (sm *StateMachine[O]) restoreLocked(
+	codec Codec, ms *machineSnap,
+) (func(), error) {
+	sm.mux.Lock()
+	defer sm.mux.Unlock()
+	f, err := sm.restoreOn(codec, ms)
+	if err != nil {
+		return nil, err
+	}
+	return func() {
+		sm.mux.Lock()
+		defer sm.mux.Unlock()
+		f()
+	}, nil
+}
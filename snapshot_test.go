@@ -0,0 +1,88 @@
+package genesm
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// profile is an example value of state which have exported fields
+type profile struct {
+	Name  string
+	Score int
+}
+
+func TestSnapshot(t *testing.T) {
+	// state transition:
+	//   -> guest <-> member{ bronze -> gold }
+	type machine struct {
+		sm        *StateMachine[string]
+		bndGuest  StateBinder[string, string]
+		bndMember StateBinder[string, profile]
+		bndBronze StateBinder[string, int]
+		bndGold   StateBinder[string, int]
+		eJoin     EventX[string, string, profile]
+		eQuit     EventX[string, profile, string]
+		eUpgrade  EventX[string, int, int]
+	}
+	build := func() *machine {
+		m := &machine{sm: NewStateMachine("owner")}
+		m.bndGuest = RegState(m.sm, "guest")
+		m.bndMember = RegState(m.sm, profile{})
+		sub := NewSubStateMachine(m.bndMember)
+		sub.SetHistoryMode(HisShallow)
+		m.bndBronze = RegState(sub, 0)
+		m.bndGold = RegState(sub, 0)
+		m.eJoin = RegEvent(m.sm, m.bndGuest, m.bndMember)
+		m.eQuit = RegEvent(m.sm, m.bndMember, m.bndGuest)
+		m.eUpgrade = RegEvent(sub, m.bndBronze, m.bndGold)
+		return m
+	}
+	Convey("Snapshot and restore test", t, func() {
+		src := build()
+		So(src.eJoin.Trigger(), ShouldBeNil)
+		So(src.eUpgrade.Trigger(), ShouldBeNil)
+		So(src.bndMember.Set(profile{Name: "bob", Score: 10}), ShouldBeNil)
+		So(src.bndGold.Set(3), ShouldBeNil)
+
+		for _, codec := range []Codec{nil, GobCodec} {
+			data, err := src.sm.Snapshot(codec)
+			So(err, ShouldBeNil)
+			dst := build()
+			rec := []string{}
+			ctr := NewObsSyncController(0)
+			dst.bndGuest.AddObserver(orderRecorder[string](ctr, "guest", &rec))
+			dst.bndGold.AddObserver(orderRecorder[int](ctr, "gold", &rec))
+			So(dst.sm.Restore(data, codec), ShouldBeNil)
+			So(rec, ShouldResemble, []string{"exit guest", "enter gold"})
+			So(dst.sm.StateIDs(), ShouldResemble, []StateID{
+				dst.bndMember.ID(), dst.bndGold.ID()})
+			So(dst.bndMember.Get(), ShouldResemble, profile{"bob", 10})
+			So(dst.bndGold.Get(), ShouldEqual, 3)
+			So(dst.bndGold.GetUpdTime().Equal(src.bndGold.GetUpdTime()),
+				ShouldBeTrue)
+			So(dst.eQuit.Trigger(), ShouldBeNil)
+			So(dst.eJoin.Trigger(), ShouldBeNil) // history is restored
+			So(dst.bndGold.IsSelected(), ShouldBeTrue)
+		}
+
+		// history of inactive sub state machine is kept
+		So(src.eQuit.Trigger(), ShouldBeNil)
+		data, err := src.sm.Snapshot(nil)
+		So(err, ShouldBeNil)
+		dst := build()
+		So(dst.sm.Restore(data, nil), ShouldBeNil)
+		So(dst.sm.StateIDs(), ShouldResemble, []StateID{dst.bndGuest.ID()})
+		So(dst.eJoin.Trigger(), ShouldBeNil)
+		So(dst.bndGold.IsSelected(), ShouldBeTrue)
+
+		// mismatch registrations
+		other := NewStateMachine("owner")
+		RegState(other, "guest")
+		RegState(other, profile{})
+		So(other.Restore(data, nil), ShouldEqual, ErrSnapshotMismatch)
+		So(dst.sm.Restore(data, GobCodec), ShouldNotBeNil)
+		So(dst.sm.Restore([]byte(`{"Version":2}`), nil), ShouldEqual,
+			ErrSnapshotVersion)
+	})
+}
@@ -2,6 +2,7 @@ package genesm
 
 import (
 	"errors"
+	"reflect"
 	"sync"
 	"time"
 )
@@ -9,9 +10,14 @@ import (
 // A stateAgent provide several inner methods to use for interact with
 // StateMachine
 type stateAgent[O any] interface {
-	onEnter(owner O)
-	onExit(owner O)
+	onEnter(owner O, ti *trigInfo)
+	onExit(owner O, ti *trigInfo)
 	onPick(owner O)
+	subMachine() *StateMachine[O]
+	getAny() any
+	marshal(codec Codec) ([]byte, time.Time, error)
+	unmarshal(codec Codec, data []byte, upd time.Time) (func(), error)
+	release()
 }
 
 // StateBinder is a management interface. which represent a DFA State that
@@ -29,6 +35,7 @@ type StateBinder[O any, T any] interface {
 	Get() T
 	Set(val T) error
 	GetUpdTime() time.Time
+	SubMachine() *StateMachine[O]
 	Protect(handler func(owner O, v T, selected bool))
 	AddObserver(obs Observer[O, T]) error
 }
@@ -44,6 +51,7 @@ type stateBindImp[O any, T any] struct {
 	subUpdTime time.Time
 	selected   bool
 	obs        []Observer[O, T]
+	child      *StateMachine[O] // sub state machine
 }
 
 // RegState regist a state data to StateMachine and return StateBinder to do
@@ -57,39 +65,57 @@ type stateBindImp[O any, T any] struct {
 //
 // As a variable. StateBinder managed own values. which provide Update method
 // to safely update value. also Update event will be trigger on observer
-func RegState[O any, T any](sm *StateMachine[O], state T) StateBinder[O, T] {
-	ret := &stateBindImp[O, T]{
-		parent:     sm,
-		sub:        state,
-		subUpdTime: time.Now(),
-	}
+//
+// an optional name could be given to the state. it must be unique on the state
+// machine. see LookupState and StateMachine.Describe
+func RegState[O any, T any](
+	sm *StateMachine[O], state T, name ...string,
+) StateBinder[O, T] {
+	nm := reserveName(sm, false, name)
+	ret := &stateBindImp[O, T]{}
+	regStateOn(sm, ret, state)
+	bindStateName(sm, ret.id, nm, ret)
+	return ret
+}
+
+// regStateOn regist a state binder which is allocated by caller. so binders
+// of a state machine could be allocated at once. see Template
+func regStateOn[O any, T any](
+	sm *StateMachine[O], sb *stateBindImp[O, T], state T,
+) {
+	sb.parent, sb.sub, sb.subUpdTime = sm, state, time.Now()
 	sm.regState(func(id StateID) stateAgent[O] {
-		ret.id = id
-		if id.RegSerial == 0 { // add for first state into state machine
-			ret.selected = true
+		sb.id = id
+		if id.RegSerial == 0 && sm.active { // add for first state into state machine
+			sb.selected = true
 		}
-		return ret
+		return sb
 	})
-	return ret
 }
 
 // onEnter handle enter event
-func (sb *stateBindImp[O, T]) onEnter(owner O) {
+func (sb *stateBindImp[O, T]) onEnter(owner O, ti *trigInfo) {
 	sb.mux.RLock()
 	defer sb.mux.RUnlock()
 	sb.selected = true
+	if sb.parent.isSilent() {
+		return
+	}
 	for _, ob := range sb.obs {
-		ob.enter(owner, sb.id, sb.sub)
+		ob.enter(owner, sb.id, sb.sub, ti)
 	}
 }
 
 // onExit handle exit event
-func (sb *stateBindImp[O, T]) onExit(owner O) {
+func (sb *stateBindImp[O, T]) onExit(owner O, ti *trigInfo) {
 	sb.mux.RLock()
 	defer sb.mux.RUnlock()
 	sb.selected = false
+	if sb.parent.isSilent() {
+		return
+	}
 	for _, ob := range sb.obs {
-		ob.exit(owner, sb.id, sb.sub)
+		ob.exit(owner, sb.id, sb.sub, ti)
 	}
 }
 
@@ -110,6 +136,61 @@ func (sb *stateBindImp[O, T]) Parent() *StateMachine[O] { return sb.parent }
 func (sb *stateBindImp[O, T]) IsSelected() bool         { return sb.selected }
 func (sb *stateBindImp[O, T]) Get() T                   { return sb.sub }
 
+// SubMachine get sub state machine of the State. it return nil if the State
+// have no sub state machine
+func (sb *stateBindImp[O, T]) SubMachine() *StateMachine[O] {
+	sb.parent.mux.RLock()
+	defer sb.parent.mux.RUnlock()
+	return sb.child
+}
+
+// subMachine implement stateAgent. caller must hold lock of state machine
+func (sb *stateBindImp[O, T]) subMachine() *StateMachine[O] {
+	return sb.child
+}
+
+// getAny implement stateAgent. it get contained value as any type
+func (sb *stateBindImp[O, T]) getAny() any {
+	sb.mux.RLock()
+	defer sb.mux.RUnlock()
+	return sb.sub
+}
+
+// marshal implement stateAgent. it encode contained value by codec
+func (sb *stateBindImp[O, T]) marshal(codec Codec) ([]byte, time.Time, error) {
+	sb.mux.RLock()
+	defer sb.mux.RUnlock()
+	data, err := codec.Marshal(sb.sub)
+	return data, sb.subUpdTime, err
+}
+
+// unmarshal implement stateAgent. it decode value by codec and return a
+// function to update contained value. observers are notified on update.
+//
+// if T is an interface type (such as states of Builder), value is decoded to
+// dynamic type of contained value. so an int is not turned into float64 by
+// JSONCodec, and GobCodec could decode it.
+func (sb *stateBindImp[O, T]) unmarshal(
+	codec Codec, data []byte, upd time.Time,
+) (func(), error) {
+	var val T
+	dyn := reflect.TypeOf(sb.getAny())
+	if dyn != nil && reflect.TypeOf(&val).Elem().Kind() == reflect.Interface {
+		pv := reflect.New(dyn)
+		if err := codec.Unmarshal(data, pv.Interface()); err != nil {
+			return nil, err
+		}
+		val, _ = pv.Elem().Interface().(T)
+	} else if err := codec.Unmarshal(data, &val); err != nil {
+		return nil, err
+	}
+	return func() {
+		sb.mux.Lock()
+		defer sb.mux.Unlock()
+		sb.set(val, upd)
+	}, nil
+}
+
 // Protect run your function with state data and state machine under mutex
 // protected
 func (sb *stateBindImp[O, T]) Protect(
@@ -124,16 +205,40 @@ func (sb *stateBindImp[O, T]) Protect(
 	handler(sb.parent.owner, sb.sub, sb.selected)
 }
 
-// Set use to update contain data for a State
+// release implement stateAgent. it release all observers
+func (sb *stateBindImp[O, T]) release() {
+	sb.mux.Lock()
+	defer sb.mux.Unlock()
+	for _, ob := range sb.obs {
+		ob.release(sb.id)
+	}
+	sb.obs = nil
+}
+
+// Set use to update contain data for a State. it return ErrClosed if the
+// state machine is closed
 func (sb *stateBindImp[O, T]) Set(val T) error {
+	if sb.parent.isClosed() {
+		return ErrClosed
+	}
 	sb.mux.Lock()
 	defer sb.mux.Unlock()
+	now := time.Now()
+	sb.set(val, now)
+	sb.parent.root().jnl.set(sb.parent.path, sb.id.RegSerial, val, now)
+	return nil
+}
+
+// set update contained value and notify observers. caller must hold the lock
+func (sb *stateBindImp[O, T]) set(val T, upd time.Time) {
 	sb.sub = val
-	sb.subUpdTime = time.Now()
+	sb.subUpdTime = upd
+	if sb.parent.isSilent() {
+		return
+	}
 	for _, ob := range sb.obs {
 		ob.update(sb.parent.owner, sb.id, sb.sub)
 	}
-	return nil
 }
 
 // addObserver is low-level method for append a state observer
@@ -152,6 +257,8 @@ func (sb *stateBindImp[O, T]) AddObserver(obs Observer[O, T]) error {
 	defer sb.mux.Unlock()
 	if obs == nil {
 		return errors.New("observer can not be nil")
+	} else if sb.parent.isClosed() {
+		return ErrClosed
 	}
 	return sb.addObserver(obs)
 }
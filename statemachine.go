@@ -1,7 +1,11 @@
 package genesm
 
 import (
+	"bytes"
+	"context"
 	"errors"
+	"runtime"
+	"strconv"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -13,8 +17,22 @@ var (
 	ErrEvInvalidChange   = errors.New("invalid target state to change")
 	ErrEvNothingTodo     = errors.New("nothing to change")
 	ErrEvUnexpectedState = errors.New("unexpected current state")
+	ErrEvDeferred        = errors.New("event deferred after transition")
 
-	ErrNoState = errors.New("no status in state machine")
+	ErrNoState      = errors.New("no status in state machine")
+	ErrInactive     = errors.New("state machine is inactive")
+	ErrInvalidState = errors.New("state is not registed in state machine")
+	ErrFinished     = errors.New("state machine is finished")
+	ErrClosed       = errors.New("state machine is closed")
+)
+
+// HistoryMode represent how a sub state machine select state on re-entering
+type HistoryMode int
+
+const (
+	HisNone    HistoryMode = iota // always enter the initial state
+	HisShallow                    // enter last selected state
+	HisDeep                       // enter last selected states of whole chain
 )
 
 // StateID is serial number to identify a registed state
@@ -44,22 +62,131 @@ var statemachineSerial = uint32(time.Now().UnixNano() % 0x80000000)
 // occured it will try automatically change current State to next one and keep
 // it select until next action be trigger.
 //
+// A StateMachine could be a sub state machine of a State which managed by
+// another StateMachine (see NewSubStateMachine). sub state machine is only
+// active when its upper State is selected.
+//
+// A StateMachine could also have several orthogonal regions (see NewRegion).
+// each region is independent with its own selected state and events. regions
+// are active together with the StateMachine.
+//
 // all methods which StateMachine exported are thread-safe.
 type StateMachine[O any] struct {
+	// IMPORTANT: If both StateMachine and its sub state machine are required.
+	//            upper StateMachine MUST be lock at first.
 	mux      sync.RWMutex
 	owner    O
 	smSerial uint32
 	stateTab []stateAgent[O]
 	stateOn  StateID
+	super    *StateMachine[O]   // upper state machine of sub state machine
+	regions  []*StateMachine[O] // orthogonal regions
+	active   bool               // whether state machine is active
+	history  HistoryMode        // history mode on re-entering
+	initial  int                // register serial of initial state
+	touched  bool               // selected state is changed after registed
+	finals   map[int]bool       // register serials of final states
+	finished bool               // whether a final state is selected
+	done     chan struct{}      // closed once a final state is entered
+	timers   []*stateTimer      // timers of timed events
+	evTab    []eventAgent       // registed events
+	grpMux   sync.Mutex         // protect groups only. never lock others inside
+	groups   [][]EventID        // event groups which have member on it
+	path     []int              // path from root state machine. see journal
+	hisBuf   []TransRecord      // ring buffer of transition history
+	hisNext  int                // next position to write in hisBuf
+	hisSize  int                // capacity of history. 0 means disabled
+
+	// journal and replay. only used on root state machine
+	jnl    journal
+	silent atomic.Bool // whether observers are silenced
+
+	closed  atomic.Bool // whether state machine is closed. only used on root
+	nameTab nameTab     // names of whole tree. only used on root
+
+	// run-to-completion queue. only used on root state machine
+	rtcMux   sync.Mutex
+	rtc      bool
+	rtcBusy  bool
+	rtcOwner uint64        // goroutine which process the queue
+	rtcIdle  chan struct{} // closed once the queue is processed
+	rtcQueue []rtcItem[O]
+}
+
+// rtcItem is a deferred transition in run-to-completion queue
+type rtcItem[O any] struct {
+	sm *StateMachine[O]
+	tr transition
 }
 
 // NewStateMachine create a new state machine instance
 func NewStateMachine[O any](owner O) *StateMachine[O] {
+	sm := newStateMachine(owner)
+	sm.active = true
+	return sm
+}
+
+// NewSubStateMachine create a sub state machine under a State.
+//
+// sub state machine share owner with upper StateMachine. once the State is
+// entered, the sub state machine will be activated and enter its initial
+// state. once the State is left, the whole chain of sub state are
+// exited at first. so observers are always notified parent first on enter and
+// child first on exit.
+//
+// events registed on upper StateMachine are apply to every descendant of the
+// State. which means an event from the State could be triggered whatever sub
+// state is selected.
+//
+// each State can only have one sub state machine.
+func NewSubStateMachine[O any, T any](sb StateBinder[O, T]) *StateMachine[O] {
+	sbi, ok := sb.(*stateBindImp[O, T])
+	if !ok {
+		panic("unsupported state binder")
+	}
+	sm := sbi.parent
+	sm.mux.Lock()
+	defer sm.mux.Unlock()
+	if sbi.child != nil {
+		panic("state already have a sub state machine")
+	}
+	sub := newStateMachine(sm.owner)
+	sub.super = sm
+	sub.path = append(append([]int(nil), sm.path...), sbi.id.RegSerial)
+	sub.active = sm.active && sm.stateOn == sbi.id
+	sbi.child = sub
+	return sub
+}
+
+// NewRegion create an orthogonal region of state machine.
+//
+// A region is a StateMachine which share owner with upper StateMachine. it
+// have own states and events, and keep own selected state independently. a
+// region is active while upper StateMachine is active. so a region of root
+// StateMachine is always active, and a region of sub state machine is active
+// while the State own the sub state machine is selected.
+//
+// On enter, regions are entered after the states of upper StateMachine. on
+// exit, regions are exited at first.
+func NewRegion[O any](sm *StateMachine[O]) *StateMachine[O] {
+	sm.mux.Lock()
+	defer sm.mux.Unlock()
+	rg := newStateMachine(sm.owner)
+	rg.super = sm
+	rg.path = append(append([]int(nil), sm.path...), -len(sm.regions)-1)
+	rg.active = sm.active
+	sm.regions = append(sm.regions, rg)
+	return rg
+}
+
+// newStateMachine create a inactive state machine
+func newStateMachine[O any](owner O) *StateMachine[O] {
 	seq := atomic.AddUint32(&statemachineSerial, 1)
 	return &StateMachine[O]{
 		smSerial: seq,
 		stateOn:  StateID{SMSerial: seq},
 		owner:    owner,
+		done:     make(chan struct{}),
 	}
 }
 
@@ -70,21 +197,347 @@ func (sm *StateMachine[O]) GetOwner() O {
 	return sm.owner
 }
 
-// SetOwner set new owner to state matchine
+// SetOwner set new owner to state matchine. new owner is also set to all sub
+// state machines
 func (sm *StateMachine[O]) SetOwner(o O) {
 	sm.mux.Lock()
 	defer sm.mux.Unlock()
+	sm.setOwner(o)
+}
+
+// setOwner set owner recursively
+func (sm *StateMachine[O]) setOwner(o O) {
 	sm.owner = o
+	for _, st := range sm.stateTab {
+		if sub := st.subMachine(); sub != nil {
+			sub.mux.Lock()
+			sub.setOwner(o)
+			sub.mux.Unlock()
+		}
+	}
+	for _, rg := range sm.regions {
+		rg.mux.Lock()
+		rg.setOwner(o)
+		rg.mux.Unlock()
+	}
 }
 
-// StateID get state ID of seleted state
+// StateID get state ID of seleted state. an inactive state machine always
+// return invalid ID
 func (sm *StateMachine[O]) StateID() StateID {
+	sm.mux.RLock()
+	defer sm.mux.RUnlock()
+	return sm.selectedID()
+}
+
+// StateIDs get state IDs of all selected states. which include selected state
+// of the StateMachine itself, selected states of sub state machines and
+// regions. upper state is always ahead of its sub states.
+func (sm *StateMachine[O]) StateIDs() []StateID {
+	sm.mux.RLock()
+	defer sm.mux.RUnlock()
+	return sm.collectOn(nil)
+}
+
+// StatePaths get paths of all selected states, in the same order of StateIDs.
+// see StatePath
+func (sm *StateMachine[O]) StatePaths() []string {
+	sm.mux.RLock()
+	defer sm.mux.RUnlock()
+	ret := []string{}
+	sm.eachSelectedOn(func(m *StateMachine[O]) {
+		ret = append(ret, formatPath(m.path, m.stateOn.RegSerial))
+	})
+	return ret
+}
+
+// StatePath format a state as a path from root state machine, such as
+// "1/-1/0". the last element is register serial of the state. a non-negative
+// element N ahead of it means the sub state machine of state N, and -N means
+// region N-1.
+//
+// unlike StateID, paths are the same on state machines which are built by the
+// same registrations (such as instances of a Template). see Manager.Count.
+// it return empty string if the state is not registed in the tree of sm.
+func (sm *StateMachine[O]) StatePath(id StateID) string {
+	m := sm.root().findMachine(id.SMSerial)
+	if m == nil {
+		return ""
+	}
+	m.mux.RLock()
+	defer m.mux.RUnlock()
+	if id.RegSerial < 0 || id.RegSerial >= len(m.stateTab) {
+		return ""
+	}
+	return formatPath(m.path, id.RegSerial)
+}
+
+// findMachine find a state machine in the tree by serial. it return nil if
+// there is no such one
+func (sm *StateMachine[O]) findMachine(serial uint32) *StateMachine[O] {
+	if sm.smSerial == serial {
+		return sm
+	}
+	sm.mux.RLock()
+	subs := append([]*StateMachine[O](nil), sm.regions...)
+	for _, st := range sm.stateTab {
+		if sub := st.subMachine(); sub != nil {
+			subs = append(subs, sub)
+		}
+	}
+	sm.mux.RUnlock()
+	for _, sub := range subs {
+		if m := sub.findMachine(serial); m != nil {
+			return m
+		}
+	}
+	return nil
+}
+
+// formatPath format path of a state machine and register serial of a state.
+// see StatePath
+func formatPath(path []int, serial int) string {
+	b := make([]byte, 0, 4*len(path)+4)
+	for _, p := range path {
+		b = strconv.AppendInt(b, int64(p), 10)
+		b = append(b, '/')
+	}
+	return string(strconv.AppendInt(b, int64(serial), 10))
+}
+
+// Regions get all regions of the StateMachine
+func (sm *StateMachine[O]) Regions() []*StateMachine[O] {
+	sm.mux.RLock()
+	defer sm.mux.RUnlock()
+	return append([]*StateMachine[O](nil), sm.regions...)
+}
+
+// SetInitial mark a registed state as initial state. which is selected on a
+// sub state machine be activated, or state machine be reset. by default, the
+// first registed state is initial state.
+//
+// if the state machine is active and selected state never be changed since
+// state registed, the selection will move to new initial state. in this case
+// observers will be notified as a normal transition.
+func (sm *StateMachine[O]) SetInitial(id StateID) error {
+	sm.mux.Lock()
+	defer sm.mux.Unlock()
+	if id.SMSerial != sm.smSerial || id.RegSerial < 0 ||
+		id.RegSerial >= len(sm.stateTab) {
+		return ErrInvalidState
+	}
+	sm.initial = id.RegSerial
+	if sm.active && !sm.touched && sm.stateOn != id {
+		ti := &trigInfo{}
+		sm.exitOn(ti)
+		sm.stateOn = id
+		sm.enterOn(false, ti)
+	}
+	return nil
+}
+
+// InitialID get state ID of initial state
+func (sm *StateMachine[O]) InitialID() StateID {
 	sm.mux.RLock()
 	defer sm.mux.RUnlock()
 	if len(sm.stateTab) == 0 {
 		return STIDInvalid()
 	}
-	return sm.stateOn
+	return sm.initialID()
+}
+
+// initialID get state ID of initial state. caller must hold the lock
+func (sm *StateMachine[O]) initialID() StateID {
+	return StateID{SMSerial: sm.smSerial, RegSerial: sm.initial}
+}
+
+// Reset reset the state machine to initial state.
+//
+// selected states of whole chain (sub state machines and regions) are exited
+// at first, then initial states are entered. history of sub state machines
+// are cleared as well. observers are notified as a normal transition.
+//
+// reset an inactive sub state machine only clear its history.
+func (sm *StateMachine[O]) Reset() error {
+	return sm.ResetCtx(context.Background())
+}
+
+// ResetCtx is similar as Reset, but give up once ctx is done. see
+// Event.TriggerCtx
+func (sm *StateMachine[O]) ResetCtx(ctx context.Context) error {
+	return sm.transform(transition{
+		kind:   trsReset,
+		event:  EvIDInvalid(),
+		target: STIDInvalid(),
+		ctx:    ctx,
+	})
+}
+
+// Close shut down the state machine. only root state machine could be closed.
+//
+// the whole chain of selected states are exited as a normal transition. then
+// all observers are released. frame observers are detached from their
+// tickers, and controllers which created by observers implicitly are
+// stopped. names of states and events are released as well. channels which
+// returned by Done of the whole tree are closed, so goroutines waiting on
+// them are released. IsFinished still report whether a final state was
+// selected.
+//
+// after closed, Trigger, Reset, Set and Restore return ErrClosed. close a
+// closed state machine return ErrClosed too.
+func (sm *StateMachine[O]) Close() error {
+	if sm.super != nil {
+		return ErrNotRoot
+	}
+	sm.mux.Lock()
+	defer sm.mux.Unlock()
+	if !sm.closed.CompareAndSwap(false, true) {
+		return ErrClosed
+	}
+	ti := &trigInfo{}
+	if sm.active {
+		sm.leaveOn(ti)
+		sm.active = false
+	}
+	sm.releaseOn()
+	sm.clearNames()
+	return ti.err
+}
+
+// isClosed check whether the state machine tree is closed
+func (sm *StateMachine[O]) isClosed() bool {
+	return sm.root().closed.Load()
+}
+
+// releaseOn release observers and timers of whole chain of state machines,
+// and close their Done channels. caller must hold the lock
+func (sm *StateMachine[O]) releaseOn() {
+	for _, t := range sm.timers {
+		t.disarm()
+	}
+	select {
+	case <-sm.done: // already finished
+	default:
+		close(sm.done)
+	}
+	for _, st := range sm.stateTab {
+		st.release()
+		if sub := st.subMachine(); sub != nil {
+			sub.mux.Lock()
+			sub.releaseOn()
+			sub.mux.Unlock()
+		}
+	}
+	for _, rg := range sm.regions {
+		rg.mux.Lock()
+		rg.releaseOn()
+		rg.mux.Unlock()
+	}
+}
+
+// SetFinal mark registed states as final states.
+//
+// once a final state is entered, the state machine is finished. the channel
+// returned by Done is closed and any further event of the state machine
+// return ErrFinished. a finished state machine could be restarted by Reset.
+// a sub state machine is also restarted when it is re-entered.
+//
+// if selected state of an active state machine is marked, it is finished
+// immediately.
+func (sm *StateMachine[O]) SetFinal(ids ...StateID) error {
+	sm.mux.Lock()
+	defer sm.mux.Unlock()
+	for _, id := range ids {
+		if id.SMSerial != sm.smSerial || id.RegSerial < 0 ||
+			id.RegSerial >= len(sm.stateTab) {
+			return ErrInvalidState
+		}
+	}
+	if sm.finals == nil {
+		sm.finals = map[int]bool{}
+	}
+	for _, id := range ids {
+		sm.finals[id.RegSerial] = true
+	}
+	if sm.active && len(sm.stateTab) != 0 {
+		sm.checkFinal()
+	}
+	return nil
+}
+
+// IsFinal check whether a state is marked as final state
+func (sm *StateMachine[O]) IsFinal(id StateID) bool {
+	sm.mux.RLock()
+	defer sm.mux.RUnlock()
+	return id.SMSerial == sm.smSerial && sm.finals[id.RegSerial]
+}
+
+// Done return a channel that is closed once state machine enter a final
+// state. after the state machine is restarted, a new channel is returned. it
+// is closed as well once the state machine is closed. see Close
+func (sm *StateMachine[O]) Done() <-chan struct{} {
+	sm.mux.RLock()
+	defer sm.mux.RUnlock()
+	return sm.done
+}
+
+// IsFinished check whether state machine is finished
+func (sm *StateMachine[O]) IsFinished() bool {
+	sm.mux.RLock()
+	defer sm.mux.RUnlock()
+	return sm.finished
+}
+
+// FinalValue get value of the final state which state machine stay on. ok is
+// false if the state machine is not finished
+func (sm *StateMachine[O]) FinalValue() (val any, ok bool) {
+	sm.mux.RLock()
+	defer sm.mux.RUnlock()
+	if !sm.finished {
+		return nil, false
+	}
+	return sm.stateTab[sm.stateOn.RegSerial].getAny(), true
+}
+
+// checkFinal finish state machine if selected state is a final state. caller
+// must hold the lock
+func (sm *StateMachine[O]) checkFinal() {
+	if !sm.finished && !sm.isClosed() && sm.finals[sm.stateOn.RegSerial] {
+		sm.finished = true
+		close(sm.done)
+	}
+}
+
+// SetHistoryMode set history mode of state machine. it decide which state will
+// be selected when a sub state machine is re-entered.
+//
+// HisNone is default mode. the initial state is always selected.
+//
+// HisShallow make state machine select the state that was selected when it
+// was left last time. sub state machines of that state use their own mode.
+//
+// HisDeep make the whole chain of state machines under this one select last
+// selected states, whatever mode they are.
+//
+// history mode do nothing on root state machine. since it is never re-entered.
+func (sm *StateMachine[O]) SetHistoryMode(mode HistoryMode) {
+	sm.mux.Lock()
+	defer sm.mux.Unlock()
+	sm.history = mode
+}
+
+// Super get upper state machine. it return nil if state machine is neither a
+// sub state machine nor a region
+func (sm *StateMachine[O]) Super() *StateMachine[O] {
+	return sm.super
+}
+
+// IsActive check whether state machine is active. a root state machine is
+// always active
+func (sm *StateMachine[O]) IsActive() bool {
+	sm.mux.RLock()
+	defer sm.mux.RUnlock()
+	return sm.active
 }
 
 // Serial get serial number of StateMachine
@@ -92,17 +545,31 @@ func (sm *StateMachine[O]) Serial() uint32 {
 	return sm.smSerial
 }
 
-// PickState trigger a Pick action on current selected state
+// PickState trigger a Pick action on current selected state and all selected
+// sub states. selected states of regions are picked as well
 func (sm *StateMachine[O]) PickState() error {
 	sm.mux.RLock()
 	defer sm.mux.RUnlock()
-	if len(sm.stateTab) == 0 {
+	if len(sm.stateTab) == 0 && len(sm.regions) == 0 {
 		return ErrNoState
+	} else if !sm.active {
+		return ErrInactive
 	}
-	sm.stateTab[sm.stateOn.RegSerial].onPick(sm.owner)
+	sm.pickOn()
 	return nil
 }
 
+// regEvent regist a new event to state machine. it is similar as regState
+func (sm *StateMachine[O]) regEvent(convert func(EventID) eventAgent) {
+	sm.mux.Lock()
+	defer sm.mux.Unlock()
+	e := convert(EventID{
+		SMSerial:  sm.smSerial,
+		RegSerial: len(sm.evTab),
+	})
+	sm.evTab = append(sm.evTab, e)
+}
+
 // regState regist a new state to state machine
 //
 // the convert is the constructor of state. state matchine will pass new state
@@ -118,26 +585,525 @@ func (sm *StateMachine[O]) regState(convert func(StateID) stateAgent[O]) {
 	sm.stateTab = append(sm.stateTab, s)
 }
 
+// transKind represent kind of transition
+type transKind int
+
+const (
+	trsExternal transKind = iota // change to another state
+	trsSelf                      // exit and re-enter current state
+	trsInternal                  // keep current state without exit and enter
+	trsReset                     // reset state machine to initial state
+)
+
+// transition describe a request of state transform
+type transition struct {
+	kind   transKind
+	event  EventID // event which request the transition
+	target StateID // expected state after transition
+	// next select new state ID from current state ID. it return a invalid ID
+	// to break transform, and an error to cancel transform
+	next    func(cur StateID) (StateID, error)
+	ctx     context.Context // context of trigger. nil means no deadline
+	payload any             // payload which deliver to observers
+	// action is run after the transition is done. it receive new state ID
+	action func(StateID)
+}
+
+// trigInfo carry information of the trigger which cause a transition. it is
+// passed to observers through the chain of states
+type trigInfo struct {
+	ctx     context.Context
+	payload any
+	err     error // first error on dispatch observer events
+}
+
+// getPayload get payload of trigger. it is safe on nil
+func (ti *trigInfo) getPayload() any {
+	if ti == nil {
+		return nil
+	}
+	return ti.payload
+}
+
+// SetRunToCompletion switch run-to-completion mode.
+//
+// In run-to-completion mode, an event which triggered by a transition in
+// progress (in hook, action or synchonous observer, on the same goroutine)
+// will not wait for the state machine. it is queued and ErrEvDeferred is
+// returned immediately. once current transition finished, queued events are
+// processed in order by the trigger of current transition. so it is safe to
+// trigger events in hook or synchonous observer. events which triggered from
+// other goroutines wait for current transition and its queued events as
+// normal.
+//
+// the trigger which process the queue return its own error. if it is
+// succeed, the first error of queued events is returned instead.
+//
+// the mode affect the whole tree of state machine. includes all sub state
+// machines and regions. events triggered from any of them share one queue.
+func (sm *StateMachine[O]) SetRunToCompletion(on bool) {
+	rt := sm.root()
+	rt.rtcMux.Lock()
+	defer rt.rtcMux.Unlock()
+	rt.rtc = on
+}
+
+// root get root state machine
+func (sm *StateMachine[O]) root() *StateMachine[O] {
+	rt := sm
+	for rt.super != nil {
+		rt = rt.super
+	}
+	return rt
+}
+
 // transform do state transform
 //
-// pass the argument trs to do transform from current state ID to new state ID.
+// tr.next is called to do transform from current state ID to new state ID.
 // if transform is succeed, it return new state ID, else it return a nagtive
 // number to break.
-func (sm *StateMachine[O]) transform(trs func(StateID) StateID) error {
-	sm.mux.Lock()
+//
+// if context of tr is done before state machine be locked, it give up and
+// return error of context. if context is done while observer events are
+// dispatching, rest events are dropped and error of context is returned. but
+// the transition is already done.
+//
+// in run-to-completion mode, transition is queued if it is triggered by
+// another one in progress, or wait for it if it is triggered from another
+// goroutine. see SetRunToCompletion. during replay, transitions which are not
+// applied from journal are dropped. see Replay
+func (sm *StateMachine[O]) transform(tr transition) error {
+	rt := sm.root()
+	if !rt.jnl.admit() {
+		return ErrReplaying
+	}
+	var gid uint64
+	for {
+		rt.rtcMux.Lock()
+		if !rt.rtc {
+			rt.rtcMux.Unlock()
+			return sm.doTransform(tr)
+		} else if !rt.rtcBusy {
+			break
+		}
+		if gid == 0 {
+			gid = goroutineID()
+		}
+		if rt.rtcOwner == gid { // triggered by transition in progress
+			rt.rtcQueue = append(rt.rtcQueue, rtcItem[O]{sm: sm, tr: tr})
+			rt.rtcMux.Unlock()
+			return ErrEvDeferred
+		}
+		idle := rt.rtcIdle
+		rt.rtcMux.Unlock()
+		if err := waitCtx(tr.ctx, idle); err != nil {
+			return err
+		}
+	}
+	if gid == 0 {
+		gid = goroutineID()
+	}
+	rt.rtcBusy, rt.rtcOwner, rt.rtcIdle = true, gid, make(chan struct{})
+	rt.rtcMux.Unlock()
+	rerr := sm.doTransform(tr)
+	for {
+		rt.rtcMux.Lock()
+		if len(rt.rtcQueue) == 0 {
+			rt.rtcQueue = nil
+			rt.rtcBusy, rt.rtcOwner = false, 0
+			close(rt.rtcIdle)
+			rt.rtcMux.Unlock()
+			return rerr
+		}
+		item := rt.rtcQueue[0]
+		rt.rtcQueue = rt.rtcQueue[1:]
+		rt.rtcMux.Unlock()
+		if err := item.sm.doTransform(item.tr); err != nil && rerr == nil {
+			rerr = err
+		}
+	}
+}
+
+// waitCtx wait until ch is closed. it give up and return error of context once
+// ctx is done
+func waitCtx(ctx context.Context, ch <-chan struct{}) error {
+	if ctx == nil {
+		<-ch
+		return nil
+	}
+	select {
+	case <-ch:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// goroutineID get ID of current goroutine. it is parsed from head of stack
+// trace, such as "goroutine 42 [running]:"
+func goroutineID() uint64 {
+	var buf [64]byte
+	b := buf[:runtime.Stack(buf[:], false)]
+	b = bytes.TrimPrefix(b, []byte("goroutine "))
+	if i := bytes.IndexByte(b, ' '); i >= 0 {
+		b = b[:i]
+	}
+	id, _ := strconv.ParseUint(string(b), 10, 64)
+	return id
+}
+
+// doTransform do state transform under protected. the result is recorded to
+// history if it is enabled
+func (sm *StateMachine[O]) doTransform(tr transition) error {
+	if err := sm.lockCtx(tr.ctx); err != nil {
+		return err
+	}
 	defer sm.mux.Unlock()
-	next := trs(sm.stateOn)
-	if next == sm.stateOn { // transform is done before
-		return ErrEvNothingTodo
+	from := sm.selectedID()
+	err := sm.transformOn(tr)
+	sm.record(tr, from, err)
+	return err
+}
+
+// selectedID get ID of selected state. it return invalid ID if no state is
+// selected. caller must hold the lock
+func (sm *StateMachine[O]) selectedID() StateID {
+	if len(sm.stateTab) == 0 || !sm.active {
+		return STIDInvalid()
+	}
+	return sm.stateOn
+}
+
+// transformOn do state transform. caller must hold the lock
+func (sm *StateMachine[O]) transformOn(tr transition) error {
+	if sm.isClosed() {
+		return ErrClosed
+	} else if tr.kind == trsReset {
+		return sm.resetOn(tr)
+	} else if !sm.active { // no state is selected on inactive state machine
+		if _, err := tr.next(STIDInvalid()); err != nil {
+			return err
+		}
+		return ErrInactive
+	} else if sm.finished {
+		return ErrFinished
+	}
+	next, err := tr.next(sm.stateOn)
+	if err != nil {
+		return err
 	} else if next.IsInvalid() { // break transform
 		return nil
 	} else if next.RegSerial >= len(sm.stateTab) {
 		return ErrEvInvalidChange
 	}
-	sm.stateTab[sm.stateOn.RegSerial].onExit(sm.owner)
+	switch tr.kind {
+	case trsInternal: // only run action without exit and enter
+		if next != sm.stateOn {
+			return ErrEvInvalidChange
+		}
+		sm.root().jnl.trigger(sm.path, tr)
+		if tr.action != nil {
+			tr.action(next)
+		}
+		return nil
+	case trsSelf:
+		if next != sm.stateOn {
+			return ErrEvInvalidChange
+		}
+	default:
+		if next == sm.stateOn { // transform is done before
+			return ErrEvNothingTodo
+		}
+	}
+	sm.root().jnl.trigger(sm.path, tr)
+	ti := &trigInfo{ctx: tr.ctx, payload: tr.payload}
+	sm.exitOn(ti)
 	sm.stateOn = next
-	sm.stateTab[next.RegSerial].onEnter(sm.owner)
-	return nil
+	sm.touched = true
+	sm.enterOn(false, ti)
+	if tr.action != nil {
+		tr.action(next)
+	}
+	return ti.err
+}
+
+// resetOn do reset transition. caller must hold the lock
+func (sm *StateMachine[O]) resetOn(tr transition) error {
+	if len(sm.stateTab) == 0 && len(sm.regions) == 0 {
+		return ErrNoState
+	}
+	sm.root().jnl.trigger(sm.path, tr)
+	if !sm.active {
+		sm.clearHistory()
+		return nil
+	}
+	ti := &trigInfo{ctx: tr.ctx, payload: tr.payload}
+	sm.leaveOn(ti)
+	sm.clearHistory()
+	sm.arriveOn(false, ti)
+	return ti.err
+}
+
+// clearHistory select initial state on whole chain of inactive state
+// machines. caller must hold the lock
+func (sm *StateMachine[O]) clearHistory() {
+	sm.stateOn = sm.initialID()
+	sm.touched = true
+	for _, st := range sm.stateTab {
+		if sub := st.subMachine(); sub != nil {
+			sub.mux.Lock()
+			sub.clearHistory()
+			sub.mux.Unlock()
+		}
+	}
+	for _, rg := range sm.regions {
+		rg.mux.Lock()
+		rg.clearHistory()
+		rg.mux.Unlock()
+	}
+}
+
+// lockCtx acquire lock of state machine. it give up and return error of
+// context if ctx is done before the lock be acquired
+func (sm *StateMachine[O]) lockCtx(ctx context.Context) error {
+	if ctx == nil || ctx.Done() == nil {
+		sm.mux.Lock()
+		return nil
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if sm.mux.TryLock() {
+		return nil
+	}
+	locked := make(chan struct{})
+	go func() {
+		sm.mux.Lock()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+		return nil
+	case <-ctx.Done():
+		go func() { // release the lock once it is acquired
+			<-locked
+			sm.mux.Unlock()
+		}()
+		return ctx.Err()
+	}
+}
+
+// enterOn enter selected state then activate its sub state machine. deep
+// indicate sub state machine should restore last selected states. caller must
+// hold the lock
+func (sm *StateMachine[O]) enterOn(deep bool, ti *trigInfo) {
+	st := sm.stateTab[sm.stateOn.RegSerial]
+	st.onEnter(sm.owner, ti)
+	if sub := st.subMachine(); sub != nil {
+		sub.activate(deep, ti)
+	}
+	sm.checkFinal()
+	sm.armTimers()
+}
+
+// exitOn deactivate sub state machine of selected state then exit the state.
+// caller must hold the lock
+func (sm *StateMachine[O]) exitOn(ti *trigInfo) {
+	sm.disarmTimers()
+	st := sm.stateTab[sm.stateOn.RegSerial]
+	if sub := st.subMachine(); sub != nil {
+		sub.deactivate(ti)
+	}
+	st.onExit(sm.owner, ti)
+	if sm.finished { // restart
+		sm.finished = false
+		sm.done = make(chan struct{})
+	}
+}
+
+// stateTimer fire a timed event after its source state have been selected for
+// a dwell time.
+//
+// gen is changed on each arming and disarming under protected of state
+// machine. a timer which fired with stale generation is ignored. so it never
+// change state for a later selection of the source state.
+type stateTimer struct {
+	src   int // register serial of source state
+	dwell time.Duration
+	fire  func(gen uint64)
+	gen   uint64
+	tm    *time.Timer
+}
+
+// addTimer add a timer of timed event which leave state src after dwell. it
+// is armed immediately if its source state is selected.
+//
+// once the timer fire, trigger is called to do transform of the event. check
+// passed to trigger fail with errEvStale for a stale timer, otherwise it run
+// hook of the event by hook.
+func (sm *StateMachine[O]) addTimer(
+	src int, dwell time.Duration,
+	trigger func(ctx context.Context, check func() error) error,
+	hook func(ctx context.Context) error,
+) {
+	t := &stateTimer{src: src, dwell: dwell}
+	t.fire = func(gen uint64) {
+		ctx := context.Background()
+		trigger(ctx, func() error {
+			if t.gen != gen { // stale timer
+				return errEvStale
+			}
+			return hook(ctx)
+		})
+	}
+	sm.mux.Lock()
+	defer sm.mux.Unlock()
+	sm.timers = append(sm.timers, t)
+	if sm.active && len(sm.stateTab) != 0 && sm.stateOn.RegSerial == t.src {
+		t.arm()
+	}
+}
+
+// armTimers arm timers of selected state. caller must hold the lock
+func (sm *StateMachine[O]) armTimers() {
+	for _, t := range sm.timers {
+		if t.src == sm.stateOn.RegSerial {
+			t.arm()
+		}
+	}
+}
+
+// disarmTimers disarm timers of selected state. caller must hold the lock
+func (sm *StateMachine[O]) disarmTimers() {
+	for _, t := range sm.timers {
+		if t.src == sm.stateOn.RegSerial {
+			t.disarm()
+		}
+	}
+}
+
+// arm start the timer with a new generation
+func (t *stateTimer) arm() {
+	t.gen++
+	gen := t.gen
+	t.tm = time.AfterFunc(t.dwell, func() { t.fire(gen) })
+}
+
+// disarm stop the timer and make fired one stale
+func (t *stateTimer) disarm() {
+	t.gen++
+	if t.tm != nil {
+		t.tm.Stop()
+		t.tm = nil
+	}
+}
+
+// pickOn pick selected state then all selected sub states and regions.
+// caller must hold the lock
+func (sm *StateMachine[O]) pickOn() {
+	if len(sm.stateTab) != 0 {
+		st := sm.stateTab[sm.stateOn.RegSerial]
+		st.onPick(sm.owner)
+		if sub := st.subMachine(); sub != nil {
+			sub.pickActive()
+		}
+	}
+	for _, rg := range sm.regions {
+		rg.pickActive()
+	}
+}
+
+// pickActive pick a sub state machine or region if it is active
+func (sm *StateMachine[O]) pickActive() {
+	sm.mux.RLock()
+	defer sm.mux.RUnlock()
+	if sm.active {
+		sm.pickOn()
+	}
+}
+
+// collectOn append all selected state ID to ids. caller must hold the lock
+func (sm *StateMachine[O]) collectOn(ids []StateID) []StateID {
+	sm.eachSelectedOn(func(m *StateMachine[O]) {
+		ids = append(ids, m.stateOn)
+	})
+	return ids
+}
+
+// eachSelectedOn call f with each state machine of whole chain which have a
+// selected state. upper state machine is visited ahead of its sub state
+// machines. f is called under protected of the state machine. caller must
+// hold the lock
+func (sm *StateMachine[O]) eachSelectedOn(f func(m *StateMachine[O])) {
+	if !sm.active {
+		return
+	}
+	if len(sm.stateTab) != 0 {
+		f(sm)
+		if sub := sm.stateTab[sm.stateOn.RegSerial].subMachine(); sub != nil {
+			sub.mux.RLock()
+			sub.eachSelectedOn(f)
+			sub.mux.RUnlock()
+		}
+	}
+	for _, rg := range sm.regions {
+		rg.mux.RLock()
+		rg.eachSelectedOn(f)
+		rg.mux.RUnlock()
+	}
+}
+
+// activate activate a sub state machine, enter its initial state and then
+// activate its regions.
+//
+// if deep is true or history mode is set, last selected state will be entered
+// instead of initial state.
+func (sm *StateMachine[O]) activate(deep bool, ti *trigInfo) {
+	sm.mux.Lock()
+	defer sm.mux.Unlock()
+	if sm.active {
+		return
+	}
+	sm.active = true
+	sm.touched = true
+	if !deep && sm.history == HisNone {
+		sm.stateOn = sm.initialID()
+	}
+	sm.arriveOn(deep || sm.history == HisDeep, ti)
+}
+
+// deactivate deactivate regions of a sub state machine, exit its selected
+// state and then deactivate it
+func (sm *StateMachine[O]) deactivate(ti *trigInfo) {
+	sm.mux.Lock()
+	defer sm.mux.Unlock()
+	if !sm.active {
+		return
+	}
+	sm.leaveOn(ti)
+	sm.active = false
+}
+
+// arriveOn enter selected state then activate regions. caller must hold the
+// lock
+func (sm *StateMachine[O]) arriveOn(deep bool, ti *trigInfo) {
+	if len(sm.stateTab) != 0 {
+		sm.enterOn(deep, ti)
+	}
+	for _, rg := range sm.regions {
+		rg.activate(deep, ti)
+	}
+}
+
+// leaveOn deactivate regions then exit selected state. caller must hold the
+// lock
+func (sm *StateMachine[O]) leaveOn(ti *trigInfo) {
+	for i := len(sm.regions) - 1; i >= 0; i-- {
+		sm.regions[i].deactivate(ti)
+	}
+	if len(sm.stateTab) != 0 {
+		sm.exitOn(ti)
+	}
 }
 
 // IsInvalid check whether stateID is invalid
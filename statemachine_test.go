@@ -0,0 +1,460 @@
+package genesm
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// orderRecorder create a synchonous observer that record enter/exit order
+func orderRecorder[T any](
+	ctr ObsController, name string, rec *[]string,
+) Observer[string, T] {
+	return CreateEventObserver(ctr, ObsEventFuncs(
+		func(owner string, id StateID, val T) {
+			*rec = append(*rec, fmt.Sprint("enter ", name))
+		},
+		func(owner string, id StateID, val T) {
+			*rec = append(*rec, fmt.Sprint("exit ", name))
+		},
+		func(owner string, id StateID, val T) {
+			*rec = append(*rec, fmt.Sprint("pick ", name))
+		}, nil,
+	), nil)
+}
+
+func TestSubStateMachine(t *testing.T) {
+	// state transition:
+	//   -> root +-> one -> root
+	//           |
+	//           +-> two{ 2a -> 2b{ 2b1 } } -> root
+	Convey("Sub state machine test", t, func() {
+		rec := []string{}
+		ctr := NewObsSyncController(0)
+		sm := NewStateMachine("owner")
+		bndRoot := RegState(sm, 0)
+		bndOne := RegState(sm, 1)
+		bndTwo := RegState(sm, 2)
+		So(bndTwo.SubMachine(), ShouldBeNil)
+		sub := NewSubStateMachine(bndTwo)
+		So(bndTwo.SubMachine(), ShouldEqual, sub)
+		So(sub.Super(), ShouldEqual, sm)
+		So(sub.IsActive(), ShouldBeFalse)
+		So(func() { NewSubStateMachine(bndTwo) }, ShouldPanic)
+		bnd2a := RegState(sub, "2a")
+		bnd2b := RegState(sub, "2b")
+		subsub := NewSubStateMachine(bnd2b)
+		bnd2b1 := RegState(subsub, "2b1")
+		So(bnd2a.IsSelected(), ShouldBeFalse)
+		So(sub.StateID(), ShouldEqual, STIDInvalid())
+		So(sub.PickState(), ShouldEqual, ErrInactive)
+
+		bndRoot.AddObserver(orderRecorder[int](ctr, "root", &rec))
+		bndOne.AddObserver(orderRecorder[int](ctr, "one", &rec))
+		bndTwo.AddObserver(orderRecorder[int](ctr, "two", &rec))
+		bnd2a.AddObserver(orderRecorder[string](ctr, "2a", &rec))
+		bnd2b.AddObserver(orderRecorder[string](ctr, "2b", &rec))
+		bnd2b1.AddObserver(orderRecorder[string](ctr, "2b1", &rec))
+
+		eGo1 := RegEvent(sm, bndRoot, bndOne)
+		eGo2 := RegEvent(sm, bndRoot, bndTwo)
+		eRet1 := RegEvent(sm, bndOne, bndRoot)
+		eRet2 := RegEvent(sm, bndTwo, bndRoot)
+		eSub := RegEvent(sub, bnd2a, bnd2b)
+
+		So(eSub.Trigger(), ShouldEqual, ErrEvUnexpectedState)
+		So(eGo1.Trigger(), ShouldBeNil)
+		So(eRet1.Trigger(), ShouldBeNil)
+		So(rec, ShouldResemble, []string{
+			"exit root", "enter one", "exit one", "enter root",
+		})
+
+		rec = rec[:0]
+		So(eGo2.Trigger(), ShouldBeNil)
+		So(sub.IsActive(), ShouldBeTrue)
+		So(sub.StateID(), ShouldEqual, bnd2a.ID())
+		So(bnd2a.IsSelected(), ShouldBeTrue)
+		So(eSub.Trigger(), ShouldBeNil)
+		So(subsub.StateID(), ShouldEqual, bnd2b1.ID())
+		So(sm.PickState(), ShouldBeNil)
+		So(eRet2.Trigger(), ShouldBeNil)
+		So(rec, ShouldResemble, []string{
+			"exit root", "enter two", "enter 2a",
+			"exit 2a", "enter 2b", "enter 2b1",
+			"pick two", "pick 2b", "pick 2b1",
+			"exit 2b1", "exit 2b", "exit two", "enter root",
+		})
+		So(sub.IsActive(), ShouldBeFalse)
+		So(subsub.IsActive(), ShouldBeFalse)
+		So(bnd2b1.IsSelected(), ShouldBeFalse)
+
+		// re-enter will start from first state of sub state machine
+		So(eGo2.Trigger(), ShouldBeNil)
+		So(sub.StateID(), ShouldEqual, bnd2a.ID())
+
+		sm.SetOwner("newOwner")
+		So(subsub.GetOwner(), ShouldEqual, "newOwner")
+	})
+}
+
+func TestRegion(t *testing.T) {
+	// regions:
+	//   conn: offline <-> online{ auth: guest -> user }
+	//   ui:   menu <-> play
+	Convey("Orthogonal region test", t, func() {
+		rec := []string{}
+		ctr := NewObsSyncController(0)
+		sm := NewStateMachine("owner")
+		So(sm.PickState(), ShouldEqual, ErrNoState)
+		conn := NewRegion(sm)
+		ui := NewRegion(sm)
+		So(sm.Regions(), ShouldResemble, []*StateMachine[string]{conn, ui})
+		So(conn.Super(), ShouldEqual, sm)
+		So(conn.IsActive(), ShouldBeTrue)
+
+		bndOffline := RegState(conn, "offline")
+		bndOnline := RegState(conn, "online")
+		auth := NewRegion(NewSubStateMachine(bndOnline))
+		bndGuest := RegState(auth, "guest")
+		bndUser := RegState(auth, "user")
+		bndMenu := RegState(ui, "menu")
+		bndPlay := RegState(ui, "play")
+		So(bndOffline.IsSelected(), ShouldBeTrue)
+		So(bndMenu.IsSelected(), ShouldBeTrue)
+		So(bndGuest.IsSelected(), ShouldBeFalse)
+		So(sm.StateIDs(), ShouldResemble, []StateID{
+			bndOffline.ID(), bndMenu.ID()})
+
+		bndOnline.AddObserver(orderRecorder[string](ctr, "online", &rec))
+		bndGuest.AddObserver(orderRecorder[string](ctr, "guest", &rec))
+		bndUser.AddObserver(orderRecorder[string](ctr, "user", &rec))
+		bndPlay.AddObserver(orderRecorder[string](ctr, "play", &rec))
+
+		eConn := RegEvent(conn, bndOffline, bndOnline)
+		eDisconn := RegEvent(conn, bndOnline, bndOffline)
+		eLogin := RegEvent(auth, bndGuest, bndUser)
+		ePlay := RegEvent(ui, bndMenu, bndPlay)
+
+		So(eLogin.Trigger(), ShouldEqual, ErrEvUnexpectedState)
+		So(ePlay.Trigger(), ShouldBeNil)
+		So(eConn.Trigger(), ShouldBeNil)
+		So(sm.StateIDs(), ShouldResemble, []StateID{
+			bndOnline.ID(), bndGuest.ID(), bndPlay.ID()})
+		So(eLogin.Trigger(), ShouldBeNil)
+		So(sm.StateIDs(), ShouldResemble, []StateID{
+			bndOnline.ID(), bndUser.ID(), bndPlay.ID()})
+		So(sm.PickState(), ShouldBeNil)
+		So(eDisconn.Trigger(), ShouldBeNil)
+		So(sm.StateIDs(), ShouldResemble, []StateID{
+			bndOffline.ID(), bndPlay.ID()})
+		So(rec, ShouldResemble, []string{
+			"enter play", "enter online", "enter guest", "exit guest", "enter user",
+			"pick online", "pick user", "pick play",
+			"exit user", "exit online",
+		})
+	})
+}
+
+func TestHistoryMode(t *testing.T) {
+	// state transition:
+	//   -> menu <-> game{ lv1 -> lv2{ a -> b } }
+	Convey("History mode test", t, func() {
+		sm := NewStateMachine("owner")
+		bndMenu := RegState(sm, "menu")
+		bndGame := RegState(sm, "game")
+		game := NewSubStateMachine(bndGame)
+		bndLv1 := RegState(game, "lv1")
+		bndLv2 := RegState(game, "lv2")
+		lv2 := NewSubStateMachine(bndLv2)
+		bndA := RegState(lv2, "a")
+		bndB := RegState(lv2, "b")
+		ePlay := RegEvent(sm, bndMenu, bndGame)
+		ePause := RegEvent(sm, bndGame, bndMenu)
+		eNext := RegEvent(game, bndLv1, bndLv2)
+		eAB := RegEvent(lv2, bndA, bndB)
+
+		So(ePlay.Trigger(), ShouldBeNil)
+		So(eNext.Trigger(), ShouldBeNil)
+		So(eAB.Trigger(), ShouldBeNil)
+		So(ePause.Trigger(), ShouldBeNil)
+		So(ePlay.Trigger(), ShouldBeNil)
+		So(sm.StateIDs(), ShouldResemble, []StateID{bndGame.ID(), bndLv1.ID()})
+
+		Convey("Shallow history", func() {
+			game.SetHistoryMode(HisShallow)
+			So(eNext.Trigger(), ShouldBeNil)
+			So(eAB.Trigger(), ShouldBeNil)
+			So(ePause.Trigger(), ShouldBeNil)
+			So(bndLv2.IsSelected(), ShouldBeFalse)
+			So(ePlay.Trigger(), ShouldBeNil)
+			So(sm.StateIDs(), ShouldResemble, []StateID{
+				bndGame.ID(), bndLv2.ID(), bndA.ID()})
+		})
+
+		Convey("Deep history", func() {
+			game.SetHistoryMode(HisDeep)
+			So(eNext.Trigger(), ShouldBeNil)
+			So(eAB.Trigger(), ShouldBeNil)
+			So(ePause.Trigger(), ShouldBeNil)
+			So(ePlay.Trigger(), ShouldBeNil)
+			So(sm.StateIDs(), ShouldResemble, []StateID{
+				bndGame.ID(), bndLv2.ID(), bndB.ID()})
+			So(bndB.IsSelected(), ShouldBeTrue)
+		})
+	})
+}
+
+func TestInitialAndReset(t *testing.T) {
+	// state transition:
+	//   -> idle <-> run{ warm -> [hot] }
+	Convey("Initial state and reset test", t, func() {
+		rec := []string{}
+		ctr := NewObsSyncController(0)
+		sm := NewStateMachine("owner")
+		bndRun := RegState(sm, "run")
+		bndIdle := RegState(sm, "idle")
+		run := NewSubStateMachine(bndRun)
+		bndWarm := RegState(run, "warm")
+		bndHot := RegState(run, "hot")
+		So(sm.InitialID(), ShouldEqual, bndRun.ID())
+		So(sm.SetInitial(bndWarm.ID()), ShouldEqual, ErrInvalidState)
+		So(NewStateMachine("other").InitialID(), ShouldEqual, STIDInvalid())
+
+		bndRun.AddObserver(orderRecorder[string](ctr, "run", &rec))
+		bndIdle.AddObserver(orderRecorder[string](ctr, "idle", &rec))
+		bndWarm.AddObserver(orderRecorder[string](ctr, "warm", &rec))
+		bndHot.AddObserver(orderRecorder[string](ctr, "hot", &rec))
+
+		// selection move to initial state before any transition
+		So(sm.SetInitial(bndIdle.ID()), ShouldBeNil)
+		So(run.SetInitial(bndHot.ID()), ShouldBeNil)
+		So(sm.StateID(), ShouldEqual, bndIdle.ID())
+		So(rec, ShouldResemble, []string{
+			"exit warm", "exit run", "enter idle"})
+
+		eStart := RegEvent(sm, bndIdle, bndRun)
+		eCool := RegEvent(run, bndHot, bndWarm)
+		rec = rec[:0]
+		So(eStart.Trigger(), ShouldBeNil)
+		So(sm.StateIDs(), ShouldResemble, []StateID{bndRun.ID(), bndHot.ID()})
+		So(eCool.Trigger(), ShouldBeNil)
+		So(sm.SetInitial(bndRun.ID()), ShouldBeNil)
+		So(sm.StateID(), ShouldEqual, bndRun.ID())
+		So(sm.SetInitial(bndIdle.ID()), ShouldBeNil)
+
+		// reset exit whole chain and enter initial state
+		rec = rec[:0]
+		run.SetHistoryMode(HisShallow)
+		So(sm.Reset(), ShouldBeNil)
+		So(sm.StateIDs(), ShouldResemble, []StateID{bndIdle.ID()})
+		So(rec, ShouldResemble, []string{"exit warm", "exit run", "enter idle"})
+		So(eStart.Trigger(), ShouldBeNil)
+		So(run.StateID(), ShouldEqual, bndHot.ID()) // history is cleared
+
+		// reset inactive state machine only clear history
+		So(eCool.Trigger(), ShouldBeNil)
+		So(sm.Reset(), ShouldBeNil)
+		So(run.Reset(), ShouldBeNil)
+		So(run.IsActive(), ShouldBeFalse)
+		So(NewStateMachine("other").Reset(), ShouldEqual, ErrNoState)
+	})
+}
+
+func TestFinalState(t *testing.T) {
+	// state transition:
+	//   -> pending -> running{ dial -> [ready] } -> [done]
+	Convey("Final state test", t, func() {
+		sm := NewStateMachine("owner")
+		bndPending := RegState(sm, "pending")
+		bndRunning := RegState(sm, "running")
+		bndDone := RegState(sm, "done")
+		run := NewSubStateMachine(bndRunning)
+		bndDial := RegState(run, 1)
+		bndReady := RegState(run, 2)
+		So(sm.SetFinal(bndReady.ID()), ShouldEqual, ErrInvalidState)
+		So(sm.SetFinal(bndDone.ID()), ShouldBeNil)
+		So(run.SetFinal(bndReady.ID()), ShouldBeNil)
+		So(sm.IsFinal(bndDone.ID()), ShouldBeTrue)
+		So(sm.IsFinal(bndPending.ID()), ShouldBeFalse)
+
+		eStart := RegEvent(sm, bndPending, bndRunning)
+		eDial := RegEvent(run, bndDial, bndReady)
+		eFinish := RegEvent(sm, bndRunning, bndDone)
+		eRetry := RegEvent(sm, bndRunning, bndPending)
+		done := sm.Done()
+		_, ok := sm.FinalValue()
+		So(ok, ShouldBeFalse)
+
+		// sub state machine is restarted on re-entering
+		So(eStart.Trigger(), ShouldBeNil)
+		runDone := run.Done()
+		So(eDial.Trigger(), ShouldBeNil)
+		So(run.IsFinished(), ShouldBeTrue)
+		So(eDial.Trigger(), ShouldEqual, ErrFinished)
+		val, ok := run.FinalValue()
+		So(ok, ShouldBeTrue)
+		So(val, ShouldEqual, 2)
+		So(eRetry.Trigger(), ShouldBeNil)
+		So(eStart.Trigger(), ShouldBeNil)
+		So(run.IsFinished(), ShouldBeFalse)
+		So(run.Done(), ShouldNotEqual, runDone)
+		<-runDone
+
+		select {
+		case <-done:
+			So("should not be done", ShouldBeEmpty)
+		default:
+		}
+		go eFinish.Trigger()
+		<-done
+		So(sm.IsFinished(), ShouldBeTrue)
+		val, _ = sm.FinalValue()
+		So(val, ShouldEqual, "done")
+		So(eRetry.Trigger(), ShouldEqual, ErrFinished)
+
+		// reset restart state machine
+		So(sm.Reset(), ShouldBeNil)
+		So(sm.IsFinished(), ShouldBeFalse)
+		So(sm.StateID(), ShouldEqual, bndPending.ID())
+		So(sm.SetFinal(bndPending.ID()), ShouldBeNil)
+		So(sm.IsFinished(), ShouldBeTrue)
+	})
+}
+
+func TestNamed(t *testing.T) {
+	Convey("Named states and events test", t, func() {
+		sm := NewStateMachine("owner")
+		bndIdle := RegState(sm, 0, "idle")
+		bndRun := RegState(sm, "fast", "run")
+		bndAnon := RegState(sm, 2)
+		So(func() { RegState(sm, 3, "idle") }, ShouldPanic)
+		sub := NewSubStateMachine(bndRun)
+		bndSubIdle := RegState(sub, 0, "idle") // name is unique per machine
+		eStart := RegEvent(sm, bndIdle, bndRun, "start")
+		eStop := RegPayloadEvent[string](sm, bndRun, bndIdle, "stop")
+		So(func() { RegEvent(sm, bndIdle, bndAnon, "start") }, ShouldPanic)
+
+		So(sm.StateName(bndIdle.ID()), ShouldEqual, "idle")
+		So(sm.StateName(bndAnon.ID()), ShouldEqual, "")
+		So(sm.EventName(eStop.ID()), ShouldEqual, "stop")
+		So(sm.Describe(bndRun.ID()), ShouldEqual, "run")
+		So(sm.Describe(bndSubIdle.ID()), ShouldEqual, "idle")
+		So(sub.StateName(bndRun.ID()), ShouldEqual, "run")
+		So(fmt.Sprint(bndAnon.ID()), ShouldEqual,
+			fmt.Sprintf("%d:2", sm.Serial()))
+		So(STIDInvalid().String(), ShouldEqual, "invalid")
+		So(sm.DescribeEvent(eStart.ID()), ShouldEqual, "start")
+		So(sm.Describe(bndAnon.ID()), ShouldEqual, bndAnon.ID().String())
+
+		So(LookupState[int](sm, "idle"), ShouldEqual, bndIdle)
+		So(LookupState[int](sub, "idle"), ShouldEqual, bndSubIdle)
+		So(LookupState[int](sm, "run"), ShouldBeNil) // type mismatch
+		So(LookupState[string](sm, "run"), ShouldEqual, bndRun)
+		So(LookupState[int](sm, "none"), ShouldBeNil)
+		So(LookupEvent[Event](sm, "start"), ShouldEqual, eStart)
+		So(LookupEvent[EventX[string, int, string]](sm, "start"),
+			ShouldEqual, eStart)
+		evStop := LookupEvent[EventP[string, string, int, string]](sm, "stop")
+		So(evStop, ShouldEqual, eStop)
+		So(LookupEvent[Event](sm, "stop"), ShouldBeNil)
+
+		// name is resolved under protected of state machine
+		eStart.SetAction(func(o string, a int, b string, id StateID) {
+			So(sm.Describe(id), ShouldEqual, "run")
+		})
+		So(eStart.Trigger(), ShouldBeNil)
+		So(evStop.Trigger("done"), ShouldBeNil)
+	})
+}
+
+func TestClose(t *testing.T) {
+	Convey("Close state machine", t, func() {
+		rec := []string{}
+		ctr := NewObsSyncController(0)
+		sm := NewStateMachine("owner")
+		bndA := RegState(sm, "a", "a")
+		bndB := RegState(sm, "b")
+		sub := NewSubStateMachine(bndB)
+		bndB1 := RegState(sub, "b1")
+		rg := NewRegion(sm)
+		bndR := RegState(rg, "r")
+		eGo := RegEvent(sm, bndA, bndB, "go")
+		eBack := RegEvent(sm, bndB, bndA)
+		bndA.AddObserver(orderRecorder[string](ctr, "a", &rec))
+		bndB.AddObserver(orderRecorder[string](ctr, "b", &rec))
+		bndB1.AddObserver(orderRecorder[string](ctr, "b1", &rec))
+		bndR.AddObserver(orderRecorder[string](ctr, "r", &rec))
+
+		ticker, err := CreateObsFrameTicker(100)
+		So(err, ShouldBeNil)
+		defer ticker.Stop()
+		frames := int32(0)
+		ob := CreateFrameObserver(nil, ticker, ObsFrameFunc(
+			func(owner string, ev FrameEvent, id StateID, skipped int64, v string) {
+				atomic.AddInt32(&frames, 1)
+			}), nil)
+		So(bndB1.AddObserver(ob), ShouldBeNil)
+		So(eGo.Trigger(), ShouldBeNil)
+		rec = rec[:0]
+		done, subDone := sm.Done(), sub.Done()
+
+		So(sub.Close(), ShouldEqual, ErrNotRoot)
+		So(sm.Close(), ShouldBeNil)
+		So(rec, ShouldResemble, []string{"exit r", "exit b1", "exit b"})
+		So(sm.StateIDs(), ShouldBeEmpty)
+		So(bndB.IsSelected(), ShouldBeFalse)
+		time.Sleep(50 * time.Millisecond)
+		n := atomic.LoadInt32(&frames)
+		time.Sleep(50 * time.Millisecond)
+		So(atomic.LoadInt32(&frames), ShouldEqual, n)
+
+		So(sm.Close(), ShouldEqual, ErrClosed)
+		So(eBack.Trigger(), ShouldEqual, ErrClosed)
+		So(sm.Reset(), ShouldEqual, ErrClosed)
+		So(bndA.Set("x"), ShouldEqual, ErrClosed)
+		So(bndA.Get(), ShouldEqual, "a")
+		So(bndA.AddObserver(orderRecorder[string](ctr, "a", &rec)),
+			ShouldEqual, ErrClosed)
+		So(LookupState[string](sm, "a"), ShouldBeNil)
+		So(sm.EventName(eGo.ID()), ShouldEqual, "")
+		So(rec, ShouldHaveLength, 3)
+
+		// waiting on Done are released
+		for _, ch := range []<-chan struct{}{
+			done, subDone, sm.Done(), rg.Done()} {
+			select {
+			case <-ch:
+			default:
+				So("Done is not closed", ShouldBeEmpty)
+			}
+		}
+		So(sm.IsFinished(), ShouldBeFalse)
+		So(sm.SetFinal(bndA.ID()), ShouldBeNil)
+	})
+
+	Convey("Close stop controller", t, func() {
+		ctr := NewObsController(ObsControlCfg{})
+		done := make(chan struct{})
+		ctr.run(ctr.packEvent(STIDInvalid(), ObWEnterTimeout, func() {
+			close(done)
+		}, nil, nil))
+		ctr.Close()
+		<-done
+		ctr.Close()
+		// handlers are dropped instead of queued once closed
+		impl := ctr.(*obsControllerImpl)
+		var ran int32
+		for i := 0; i < 100; i++ {
+			impl.run(func() { atomic.AddInt32(&ran, 1) })
+			So(impl.runCtx(context.Background(), func() {
+				atomic.AddInt32(&ran, 1)
+			}), ShouldBeNil)
+		}
+		So(len(impl.evtCh), ShouldEqual, 0)
+		So(atomic.LoadInt32(&ran), ShouldEqual, 0)
+	})
+}
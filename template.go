@@ -0,0 +1,349 @@
+package genesm
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"time"
+)
+
+// Template is a compiled specification which stamp out identical state
+// machines cheaply. it is created by Builder.Template.
+//
+// the specification is checked, and values, hooks and actions are resolved
+// once on creating. instances share the transition table and names of the
+// template. each instance only keep its own owner, selected states and values
+// of states. states and events of a state machine are allocated at once.
+//
+// values which bound by Builder.BindState are copied to each instance, so
+// they must not be reference kinds (maps, slices, pointers, channels and
+// functions) which would be shared by all instances. use
+// Builder.BindStateFunc to create such values for each instance.
+//
+// names of events must be unique in the whole specification. since events of
+// an instance are retrieved by name, see Instance.Event. events are also
+// found by LookupEvent[Event] on their state machines.
+type Template[O any] struct {
+	root   *tplMachine[O]
+	events map[string]int // index of events in whole template
+	count  int            // count of events in whole template
+}
+
+// tplMachine is a state machine definition of template
+type tplMachine[O any] struct {
+	states  []tplState[O]
+	initial int
+	finals  []int
+	history HistoryMode
+	events  []tplEvent[O]
+	regions []*tplMachine[O]
+	stIndex map[string]int // index of states by name
+	evIndex map[string]int // index of events by name
+}
+
+// tplState is a state definition of template
+type tplState[O any] struct {
+	name  string
+	value stateValue
+	sub   *tplMachine[O]
+}
+
+// tplEvent is an event definition of template. it is shared by all instances
+type tplEvent[O any] struct {
+	index  int // index of event in whole template
+	name   string
+	from   int
+	to     int
+	kind   transKind
+	dwell  time.Duration
+	hook   func(context.Context, O, any, any) error
+	action func(O, any, any, StateID)
+}
+
+// Instance is a state machine which stamped out from Template. all methods of
+// StateMachine are available on it
+type Instance[O any] struct {
+	*StateMachine[O]
+	tpl    *Template[O]
+	events []*tplEventRef[O]
+	nodes  map[uint32]*tplNode[O] // state machines of instance by serial
+}
+
+// tplNode is a state machine of instance. it keep states and events which
+// registed on the state machine
+type tplNode[O any] struct {
+	tm     *tplMachine[O]
+	states []stateBindImp[O, any]
+	events []tplEventRef[O]
+}
+
+// tplEventRef is an event of instance. it refer to shared definition of
+// template and implement Event
+type tplEventRef[O any] struct {
+	id   EventID
+	sm   *StateMachine[O]
+	node *tplNode[O] // node of sm
+	def  *tplEvent[O]
+}
+
+// Template create a Template from spec. spec is checked as same as Build, and
+// names of events must be unique in the whole spec. it return an error which
+// wrap ErrSpec if a value of reference kind is bound by BindState.
+func (bd *Builder[O]) Template(spec *Spec) (*Template[O], error) {
+	if err := bd.check(spec, ""); err != nil {
+		return nil, err
+	}
+	tpl := &Template[O]{events: map[string]int{}}
+	root, err := bd.compile(tpl, spec)
+	if err != nil {
+		return nil, err
+	}
+	tpl.root = root
+	return tpl, nil
+}
+
+// compile compile a checked specification recursively
+func (bd *Builder[O]) compile(
+	tpl *Template[O], spec *Spec,
+) (*tplMachine[O], error) {
+	tm := &tplMachine[O]{stIndex: map[string]int{}, evIndex: map[string]int{}}
+	tm.history, _ = parseHistory(spec.History)
+	index := tm.stIndex
+	for i, st := range spec.States {
+		index[st.Name] = i
+		ts := tplState[O]{name: st.Name, value: bd.states[st.Name]}
+		if ts.value.fn == nil && isRefKind(ts.value.value) {
+			return nil, fmt.Errorf("%w: value of state %q is shared by "+
+				"instances, use BindStateFunc", ErrSpec, st.Name)
+		}
+		if st.Initial {
+			tm.initial = i
+		}
+		if st.Final {
+			tm.finals = append(tm.finals, i)
+		}
+		if st.Sub != nil {
+			sub, err := bd.compile(tpl, st.Sub)
+			if err != nil {
+				return nil, err
+			}
+			ts.sub = sub
+		}
+		tm.states = append(tm.states, ts)
+	}
+	for _, es := range spec.Events {
+		if _, ok := tpl.events[es.Name]; ok {
+			return nil, fmt.Errorf("%w: duplicate event %q in template",
+				ErrSpec, es.Name)
+		}
+		te := tplEvent[O]{
+			index:  tpl.count,
+			name:   es.Name,
+			from:   index[es.From],
+			to:     index[es.To],
+			hook:   bd.hooks[es.Hook],
+			action: bd.actions[es.Action],
+		}
+		switch es.Kind {
+		case "self":
+			te.kind, te.to = trsSelf, te.from
+		case "internal":
+			te.kind, te.to = trsInternal, te.from
+		}
+		if es.After != "" {
+			te.dwell, _ = time.ParseDuration(es.After)
+		}
+		tpl.events[es.Name] = tpl.count
+		tpl.count++
+		tm.evIndex[es.Name] = len(tm.events)
+		tm.events = append(tm.events, te)
+	}
+	for i := range spec.Regions {
+		rg, err := bd.compile(tpl, &spec.Regions[i])
+		if err != nil {
+			return nil, err
+		}
+		tm.regions = append(tm.regions, rg)
+	}
+	return tm, nil
+}
+
+// New create an instance of the template
+func (tpl *Template[O]) New(owner O) *Instance[O] {
+	in := &Instance[O]{
+		StateMachine: NewStateMachine(owner),
+		tpl:          tpl,
+		events:       make([]*tplEventRef[O], tpl.count),
+		nodes:        map[uint32]*tplNode[O]{},
+	}
+	in.names().shared = in
+	in.stamp(in.StateMachine, tpl.root)
+	return in
+}
+
+// stamp register states and events of a template machine recursively
+func (in *Instance[O]) stamp(sm *StateMachine[O], tm *tplMachine[O]) {
+	sm.SetHistoryMode(tm.history)
+	nd := &tplNode[O]{
+		tm:     tm,
+		states: make([]stateBindImp[O, any], len(tm.states)),
+		events: make([]tplEventRef[O], len(tm.events)),
+	}
+	in.nodes[sm.smSerial] = nd
+	for i, ts := range tm.states {
+		regStateOn(sm, &nd.states[i], ts.value.get())
+		if ts.sub != nil {
+			in.stamp(NewSubStateMachine[O, any](&nd.states[i]), ts.sub)
+		}
+	}
+	if len(nd.states) != 0 {
+		sm.SetInitial(nd.states[tm.initial].id)
+	}
+	finals := make([]StateID, 0, len(tm.finals))
+	for _, f := range tm.finals {
+		finals = append(finals, nd.states[f].id)
+	}
+	sm.SetFinal(finals...)
+	for i := range tm.events {
+		ref := &nd.events[i]
+		ref.sm, ref.node, ref.def = sm, nd, &tm.events[i]
+		sm.regEvent(func(id EventID) eventAgent {
+			ref.id = id
+			return ref
+		})
+		in.events[ref.def.index] = ref
+		if ref.def.dwell != 0 {
+			sm.addTimer(ref.def.from, ref.def.dwell, ref.trigger, ref.check)
+		}
+	}
+	for _, rg := range tm.regions {
+		in.stamp(NewRegion(sm), rg)
+	}
+}
+
+// Event get an event of instance by name. it return nil if there is no such
+// event
+func (in *Instance[O]) Event(name string) Event {
+	i, ok := in.tpl.events[name]
+	if !ok {
+		return nil
+	}
+	return in.events[i]
+}
+
+// stateName implement namer
+func (in *Instance[O]) stateName(id StateID) string {
+	nd := in.nodes[id.SMSerial]
+	if nd == nil || id.RegSerial < 0 || id.RegSerial >= len(nd.tm.states) {
+		return ""
+	}
+	return nd.tm.states[id.RegSerial].name
+}
+
+// eventName implement namer
+func (in *Instance[O]) eventName(id EventID) string {
+	nd := in.nodes[id.SMSerial]
+	if nd == nil || id.RegSerial < 0 || id.RegSerial >= len(nd.tm.events) {
+		return ""
+	}
+	return nd.tm.events[id.RegSerial].name
+}
+
+// lookup implement namer
+func (in *Instance[O]) lookup(smSerial uint32, event bool, name string) any {
+	nd := in.nodes[smSerial]
+	if nd == nil {
+		return nil
+	} else if event {
+		if i, ok := nd.tm.evIndex[name]; ok {
+			return &nd.events[i]
+		}
+	} else if i, ok := nd.tm.stIndex[name]; ok {
+		return &nd.states[i]
+	}
+	return nil
+}
+
+// isRefKind check whether a value is of reference kind which could not be
+// copied by assignment
+func isRefKind(v any) bool {
+	if v == nil {
+		return false
+	}
+	switch reflect.TypeOf(v).Kind() {
+	case reflect.Map, reflect.Slice, reflect.Pointer, reflect.UnsafePointer,
+		reflect.Chan, reflect.Func:
+		return true
+	}
+	return false
+}
+
+// ID get ID of the event
+func (ref *tplEventRef[O]) ID() EventID {
+	return ref.id
+}
+
+// Trigger trigger the event
+func (ref *tplEventRef[O]) Trigger() error {
+	return ref.TriggerCtx(context.Background())
+}
+
+// TriggerCtx trigger the event with context
+func (ref *tplEventRef[O]) TriggerCtx(ctx context.Context) error {
+	return ref.trigger(ctx, func() error {
+		return ref.check(ctx)
+	})
+}
+
+// trigger do transform of the event
+func (ref *tplEventRef[O]) trigger(
+	ctx context.Context, check func() error,
+) error {
+	a, b := &ref.node.states[ref.def.from], &ref.node.states[ref.def.to]
+	return ref.sm.transform(eventTransition(
+		ref.def.kind, ref.id, a.ID(), b.ID(), ctx, nil, check, ref.runAction))
+}
+
+// check run hook of event
+func (ref *tplEventRef[O]) check(ctx context.Context) error {
+	if ref.def.hook != nil {
+		a, b := &ref.node.states[ref.def.from], &ref.node.states[ref.def.to]
+		return ref.def.hook(ctx, ref.sm.owner, a.Get(), b.Get())
+	}
+	return nil
+}
+
+// runAction run action of event
+func (ref *tplEventRef[O]) runAction(id StateID) {
+	if ref.def.action != nil {
+		a, b := &ref.node.states[ref.def.from], &ref.node.states[ref.def.to]
+		ref.def.action(ref.sm.owner, a.Get(), b.Get(), id)
+	}
+}
+
+// replay implement eventAgent
+func (ref *tplEventRef[O]) replay(codec Codec, data []byte) error {
+	return ref.Trigger()
+}
+
+// edges implement eventAgent
+func (ref *tplEventRef[O]) edges() []EventInfo {
+	return []EventInfo{ref.info()}
+}
+
+// info implement eventAgent
+func (ref *tplEventRef[O]) info() EventInfo {
+	return EventInfo{
+		ID:    ref.id,
+		Name:  ref.def.name,
+		From:  ref.node.states[ref.def.from].id,
+		To:    ref.node.states[ref.def.to].id,
+		Kind:  EventKind(ref.def.kind),
+		Dwell: ref.def.dwell,
+	}
+}
+
+// joinGroup implement eventAgent
+func (ref *tplEventRef[O]) joinGroup(members []EventID) {
+	ref.sm.addGroup(members)
+}
@@ -0,0 +1,140 @@
+package genesm
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestTemplate(t *testing.T) {
+	Convey("Stamp out instances from template", t, func() {
+		spec, err := LoadSpec(strings.NewReader(orderSpec))
+		So(err, ShouldBeNil)
+		logs := []StateID{}
+		tpl, err := orderBuilder(itemsOf, &logs).Template(spec)
+		So(err, ShouldBeNil)
+
+		empty, full := tpl.New(0), tpl.New(3)
+		So(empty.Validate(), ShouldBeEmpty)
+		So(empty.Event("nothing"), ShouldBeNil)
+		So(empty.Event("checkout").Trigger(), ShouldNotBeNil)
+		So(full.Event("checkout").Trigger(), ShouldBeNil)
+		So(empty.Describe(empty.StateID()), ShouldEqual, "cart")
+		So(full.Describe(full.StateID()), ShouldEqual, "paying")
+		So(full.Event("switch").Trigger(), ShouldBeNil)
+		So(full.Event("cancel").Trigger(), ShouldBeNil)
+		So(full.Event("checkout").Trigger(), ShouldBeNil)
+		paying := LookupState[any](full.StateMachine, "paying").SubMachine()
+		So(paying.Describe(paying.StateID()), ShouldEqual, "bank")
+		So(full.Event("paid").Trigger(), ShouldBeNil)
+		So(full.IsFinished(), ShouldBeTrue)
+		So(empty.IsFinished(), ShouldBeFalse)
+		So(empty.Event("touch").Trigger(), ShouldBeNil)
+		So(logs, ShouldResemble, []StateID{
+			LookupState[any](full.StateMachine, "shipped").ID(),
+			LookupState[any](empty.StateMachine, "cart").ID(),
+		})
+
+		evs := full.Events()
+		So(evs, ShouldHaveLength, 4)
+		So(evs[0].Name, ShouldEqual, "checkout")
+		So(full.Describe(evs[0].From), ShouldEqual, "cart")
+		So(full.Close(), ShouldBeNil)
+		So(full.Event("cancel").Trigger(), ShouldEqual, ErrClosed)
+	})
+
+	Convey("Names of instance", t, func() {
+		spec, err := LoadSpec(strings.NewReader(orderSpec))
+		So(err, ShouldBeNil)
+		tpl, err := orderBuilder(itemsOf, nil).Template(spec)
+		So(err, ShouldBeNil)
+		in := tpl.New(0)
+		So(in.names().byName, ShouldBeNil) // shared with template
+		So(LookupEvent[Event](in.StateMachine, "checkout"),
+			ShouldEqual, in.Event("checkout"))
+		paying := LookupState[any](in.StateMachine, "paying").SubMachine()
+		So(in.Describe(LookupState[any](paying, "bank").ID()),
+			ShouldEqual, "bank")
+		So(func() { RegState(in.StateMachine, 0, "cart") }, ShouldPanic)
+		bnd := RegState(in.StateMachine, 0, "extra")
+		So(in.Describe(bnd.ID()), ShouldEqual, "extra")
+		So(LookupState[int](in.StateMachine, "extra"), ShouldEqual, bnd)
+	})
+
+	Convey("Timed event of instance", t, func() {
+		spec, err := LoadSpec(strings.NewReader(`{
+			"states": [{"name": "idle"}, {"name": "sleep"}],
+			"events": [{"name": "doze", "from": "idle", "to": "sleep",
+				"after": "20ms"}]
+		}`))
+		So(err, ShouldBeNil)
+		tpl, err := NewBuilder[string]().
+			BindState("idle", 1).BindState("sleep", 2).Template(spec)
+		So(err, ShouldBeNil)
+		in := tpl.New("owner")
+		So(in.Describe(in.StateID()), ShouldEqual, "idle")
+		time.Sleep(60 * time.Millisecond)
+		So(in.Describe(in.StateID()), ShouldEqual, "sleep")
+	})
+
+	Convey("Reject duplicate event name in template", t, func() {
+		spec, err := LoadSpec(strings.NewReader(`{
+			"states": [{"name": "a", "sub": {
+				"states": [{"name": "x"}, {"name": "y"}],
+				"events": [{"name": "go", "from": "x", "to": "y"}]
+			}}, {"name": "b"}],
+			"events": [{"name": "go", "from": "a", "to": "b"}]
+		}`))
+		So(err, ShouldBeNil)
+		_, err = NewBuilder[string]().
+			BindState("a", 1).BindState("b", 2).
+			BindState("x", 3).BindState("y", 4).Template(spec)
+		So(errors.Is(err, ErrSpec), ShouldBeTrue)
+		So(err.Error(), ShouldContainSubstring, `duplicate event "go"`)
+	})
+
+	Convey("Snapshot and restore instance", t, func() {
+		spec, err := LoadSpec(strings.NewReader(orderSpec))
+		So(err, ShouldBeNil)
+		tpl, err := orderBuilder(itemsOf, nil).
+			BindState("shipped", profile{}).Template(spec)
+		So(err, ShouldBeNil)
+		for _, codec := range []Codec{JSONCodec, GobCodec} {
+			src := tpl.New(1)
+			So(src.Event("checkout").Trigger(), ShouldBeNil)
+			So(LookupState[any](src.StateMachine, "cart").Set(5), ShouldBeNil)
+			data, err := src.Snapshot(codec)
+			So(err, ShouldBeNil)
+			dst := tpl.New(1)
+			So(dst.Restore(data, codec), ShouldBeNil)
+			So(LookupState[any](dst.StateMachine, "cart").Get(), ShouldEqual, 5)
+			So(dst.StatePaths(), ShouldResemble, src.StatePaths())
+			So(dst.Event("paid").Trigger(), ShouldBeNil)
+			So(dst.IsFinished(), ShouldBeTrue)
+		}
+	})
+
+	Convey("Values of instances", t, func() {
+		spec, err := LoadSpec(strings.NewReader(`{
+			"states": [{"name": "idle"}, {"name": "busy"}]
+		}`))
+		So(err, ShouldBeNil)
+		_, err = NewBuilder[string]().
+			BindState("idle", map[string]int{}).
+			BindState("busy", 0).Template(spec)
+		So(errors.Is(err, ErrSpec), ShouldBeTrue)
+		So(err.Error(), ShouldContainSubstring, `state "idle"`)
+
+		tpl, err := NewBuilder[string]().
+			BindStateFunc("idle", func() any { return map[string]int{} }).
+			BindState("busy", 0).Template(spec)
+		So(err, ShouldBeNil)
+		in1, in2 := tpl.New("a"), tpl.New("b")
+		m1 := LookupState[any](in1.StateMachine, "idle").Get().(map[string]int)
+		m1["x"] = 1
+		So(LookupState[any](in2.StateMachine, "idle").Get(), ShouldBeEmpty)
+	})
+}
@@ -0,0 +1,178 @@
+package genesm
+
+import (
+	"fmt"
+	"strings"
+)
+
+// IssueKind represent kind of an issue which found by Validate
+type IssueKind int
+
+const (
+	IssueUnreachable    IssueKind = iota // state can't be reached from initial
+	IssueDeadEnd                         // non-final state have no way out
+	IssueDuplicateEdge                   // events registed twice on an edge
+	IssueAmbiguousGroup                  // group members share a source state
+)
+
+// Issue is a problem of state graph which found by Validate. States and
+// Events are involved states and events
+type Issue struct {
+	Kind   IssueKind
+	States []StateID
+	Events []EventID
+}
+
+// String describe the issue with ids of states and events. use
+// StateMachine.DescribeIssue to describe it with names
+func (is Issue) String() string {
+	return is.format(StateID.String, EventID.String)
+}
+
+// DescribeIssue describe an issue with names of states and events. see
+// Describe
+func (sm *StateMachine[O]) DescribeIssue(is Issue) string {
+	return is.format(sm.Describe, sm.DescribeEvent)
+}
+
+// format describe the issue by formatter of states and events
+func (is Issue) format(
+	st func(StateID) string, ev func(EventID) string,
+) string {
+	switch is.Kind {
+	case IssueUnreachable:
+		return fmt.Sprintf("unreachable state %s", st(is.States[0]))
+	case IssueDeadEnd:
+		return fmt.Sprintf("dead end state %s", st(is.States[0]))
+	case IssueDuplicateEdge:
+		return fmt.Sprintf("duplicate events %s on %s -> %s",
+			joinEvents(is.Events, ev), st(is.States[0]), st(is.States[1]))
+	case IssueAmbiguousGroup:
+		return fmt.Sprintf("grouped events %s share source state %s",
+			joinEvents(is.Events, ev), st(is.States[0]))
+	}
+	return "unknown issue"
+}
+
+// joinEvents format a list of events
+func joinEvents(evs []EventID, ev func(EventID) string) string {
+	names := make([]string, len(evs))
+	for i, id := range evs {
+		names[i] = ev(id)
+	}
+	return strings.Join(names, ", ")
+}
+
+// Validate check state graph of the whole tree of state machine. it return
+// nothing if no issue is found. following issues are reported:
+//
+// IssueUnreachable: a state can't be reached from initial state of its state
+// machine through events.
+//
+// IssueDeadEnd: a non-final state have no event to leave. a state of sub
+// state machine (or its region) is not a dead end if any upper state of it
+// have an event to leave.
+//
+// IssueDuplicateEdge: several events of the same kind are registed between a
+// pair of states.
+//
+// IssueAmbiguousGroup: several members of an event group share a source
+// state. only the first one could be triggered by the group in this case.
+func (sm *StateMachine[O]) Validate() []Issue {
+	g := sm.buildGraph()
+	issues := validateOn(g.root, false, nil)
+	evs := map[EventID][]EventInfo{} // an event may have several edges
+	g.root.eachEvent(func(ev EventInfo) {
+		evs[ev.ID] = append(evs[ev.ID], ev)
+	})
+	for _, grp := range g.groups {
+		srcs := []StateID{}
+		bySrc := map[StateID][]EventID{}
+		for _, id := range grp {
+			for _, ev := range evs[id] { // none for member of other machine
+				if _, ok := bySrc[ev.From]; !ok {
+					srcs = append(srcs, ev.From)
+				}
+				bySrc[ev.From] = append(bySrc[ev.From], id)
+			}
+		}
+		for _, src := range srcs {
+			if len(bySrc[src]) > 1 {
+				issues = append(issues, Issue{
+					Kind:   IssueAmbiguousGroup,
+					States: []StateID{src},
+					Events: bySrc[src],
+				})
+			}
+		}
+	}
+	return issues
+}
+
+// validateOn check a state machine recursively. exits indicate whether an
+// upper state have an event to leave
+func validateOn(gm *graphMachine, exits bool, issues []Issue) []Issue {
+	type edge struct {
+		from, to StateID
+		kind     EventKind
+	}
+	outgoing := map[StateID]bool{}
+	next := map[StateID][]StateID{}
+	edges := []edge{}
+	dup := map[edge][]EventID{}
+	for _, ev := range gm.events {
+		e := edge{ev.From, ev.To, ev.Kind}
+		if _, ok := dup[e]; !ok {
+			edges = append(edges, e)
+		}
+		dup[e] = append(dup[e], ev.ID)
+		if ev.From != ev.To {
+			outgoing[ev.From] = true
+			next[ev.From] = append(next[ev.From], ev.To)
+		}
+	}
+	reached := map[StateID]bool{}
+	if !gm.initial.IsInvalid() {
+		queue := []StateID{gm.initial}
+		reached[gm.initial] = true
+		for len(queue) != 0 {
+			cur := queue[0]
+			queue = queue[1:]
+			for _, to := range next[cur] {
+				if !reached[to] {
+					reached[to] = true
+					queue = append(queue, to)
+				}
+			}
+		}
+	}
+	for i := range gm.states {
+		st := &gm.states[i]
+		if !reached[st.id] {
+			issues = append(issues, Issue{
+				Kind: IssueUnreachable, States: []StateID{st.id}})
+		}
+		if !st.final && !outgoing[st.id] && !exits {
+			issues = append(issues, Issue{
+				Kind: IssueDeadEnd, States: []StateID{st.id}})
+		}
+	}
+	for _, e := range edges {
+		if len(dup[e]) > 1 {
+			issues = append(issues, Issue{
+				Kind:   IssueDuplicateEdge,
+				States: []StateID{e.from, e.to},
+				Events: dup[e],
+			})
+		}
+	}
+	for i := range gm.states {
+		if st := &gm.states[i]; st.sub != nil {
+			issues = validateOn(st.sub, exits || outgoing[st.id], issues)
+		}
+	}
+	for _, rg := range gm.regions {
+		issues = validateOn(rg, exits, issues)
+	}
+	return issues
+}
@@ -0,0 +1,88 @@
+package genesm
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestValidate(t *testing.T) {
+	Convey("Graph validation test", t, func() {
+		// state transition:
+		//   -> idle <-> run{ warm -> hot } -> [done]
+		sm := NewStateMachine("owner")
+		bndIdle := RegState(sm, 0, "idle")
+		bndRun := RegState(sm, 1, "run")
+		bndDone := RegState(sm, 2, "done")
+		run := NewSubStateMachine(bndRun)
+		bndWarm := RegState(run, 0, "warm")
+		bndHot := RegState(run, 1, "hot")
+		sm.SetFinal(bndDone.ID())
+		eStart := RegEvent(sm, bndIdle, bndRun, "start")
+		eStop := RegEvent(sm, bndRun, bndIdle, "stop")
+		eFinish := RegEvent(sm, bndRun, bndDone, "finish")
+		RegEvent(run, bndWarm, bndHot)
+		GroupEvent(eStop, eStart)
+		So(sm.Validate(), ShouldBeEmpty)
+
+		Convey("Report wiring mistakes", func() {
+			bndLost := RegState(sm, 3, "lost")
+			eStart2 := RegEvent(sm, bndIdle, bndRun, "start2")
+			RegSelfEvent(sm, bndIdle)
+			GroupEvent(eStop, eFinish)
+			issues := sm.Validate()
+			So(issues, ShouldResemble, []Issue{
+				{Kind: IssueUnreachable, States: []StateID{bndLost.ID()}},
+				{Kind: IssueDeadEnd, States: []StateID{bndLost.ID()}},
+				{
+					Kind:   IssueDuplicateEdge,
+					States: []StateID{bndIdle.ID(), bndRun.ID()},
+					Events: []EventID{eStart.ID(), eStart2.ID()},
+				},
+				{
+					Kind:   IssueAmbiguousGroup,
+					States: []StateID{bndRun.ID()},
+					Events: []EventID{eStop.ID(), eFinish.ID()},
+				},
+			})
+			So(sm.DescribeIssue(issues[0]), ShouldEqual, "unreachable state lost")
+			So(issues[0].String(), ShouldEqual,
+				"unreachable state "+bndLost.ID().String())
+			So(sm.DescribeIssue(issues[2]), ShouldEqual,
+				"duplicate events start, start2 on idle -> run")
+			So(sm.DescribeIssue(issues[3]), ShouldEqual,
+				"grouped events stop, finish share source state run")
+		})
+
+		Convey("Dead end in sub state machine", func() {
+			sm := NewStateMachine("owner")
+			bndA := RegState(sm, "A")
+			sub := NewSubStateMachine(bndA)
+			bndA1 := RegState(sub, "A1")
+			issues := sm.Validate()
+			So(issues, ShouldResemble, []Issue{
+				{Kind: IssueDeadEnd, States: []StateID{bndA.ID()}},
+				{Kind: IssueDeadEnd, States: []StateID{bndA1.ID()}},
+			})
+			bndB := RegState(sm, "B")
+			RegEvent(sm, bndA, bndB)
+			sm.SetFinal(bndB.ID())
+			So(sm.Validate(), ShouldBeEmpty)
+		})
+
+		Convey("Any event in ambiguous group", func() {
+			sm := NewStateMachine("owner")
+			bndA, bndB := RegState(sm, "A"), RegState(sm, "B")
+			bndC := RegState(sm, "C")
+			sm.SetFinal(bndC.ID())
+			eAB := RegEvent(sm, bndA, bndB)
+			eAny := RegAnyEvent(sm, bndC, nil)
+			GroupEvent(eAB, eAny)
+			So(sm.Validate(), ShouldResemble, []Issue{{
+				Kind:   IssueAmbiguousGroup,
+				States: []StateID{bndA.ID()},
+				Events: []EventID{eAB.ID(), eAny.ID()},
+			}})
+		})
+	})
+}